@@ -5,8 +5,9 @@ go 1.24.3
 require (
 	gioui.org v0.8.0
 	github.com/PuerkitoBio/goquery v1.10.3
-	github.com/google/uuid v1.6.0
 	github.com/ncruces/zenity v0.10.14
+	golang.org/x/net v0.39.0
+	golang.org/x/text v0.24.0
 )
 
 require (
@@ -20,7 +21,5 @@ require (
 	golang.org/x/exp v0.0.0-20240707233637-46b078467d37 // indirect
 	golang.org/x/exp/shiny v0.0.0-20240707233637-46b078467d37 // indirect
 	golang.org/x/image v0.20.0 // indirect
-	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
-	golang.org/x/text v0.24.0 // indirect
 )
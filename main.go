@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gioui.org/app"
 	"gioui.org/layout"
@@ -15,6 +17,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"github.com/ncruces/zenity"
 	"mhtmlExtractor/mhtmlparser" 
 )
@@ -33,6 +36,22 @@ type Resource struct {
 	Selected bool
 }
 
+// spillThreshold keeps parts larger than 64 MB on disk so huge archives don't exhaust memory.
+const spillThreshold = 64 << 20
+
+// httpCacheDir returns the directory where downloaded resources are cached between runs,
+// or "" when the system has no user cache directory.
+func httpCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mhtml-extractor", "http")
+}
+
+// httpCacheMaxSize bounds the download cache; the least recently used responses are removed past it.
+const httpCacheMaxSize = 256 << 20
+
 type MHTMLApp struct {
 	window           *app.Window
 	theme            *material.Theme
@@ -43,14 +62,33 @@ type MHTMLApp struct {
 	darkModeBtn      widget.Clickable
 	extractBtn       widget.Clickable
 	outputDirBtn     widget.Clickable
+	metadataBtn      widget.Clickable
+	exportSiteBtn    widget.Clickable
+	singleFileBtn    widget.Clickable
+	saveAsBtn        widget.Clickable
+	pruneBtn         widget.Clickable
+	pruneRules       widget.Editor
+	pruneReport      *mhtmlparser.PruneReport
+	cancelBtn        widget.Clickable
 	fetchExternalBtn widget.Bool
+	offlineBtn       widget.Bool
+	clearCacheBtn    widget.Clickable
+	mirrorPathsBtn   widget.Bool
 	rawContent       widget.Editor
 	status           string
 	selectedFile     string
 	outputDir        string
 	resources        []Resource
 	checkBoxes       []widget.Bool
+	replaceBtns      []widget.Clickable
+	removeBtns       []widget.Clickable
 	parser           *mhtmlparser.MHTMLParser
+	task             string             // Running background task: "parse", "extract", "export", "single", "save" or ""
+	taskID           int                // Incremented per task so stale results are ignored
+	cancel           context.CancelFunc // Cancels the running task
+	done             chan func()        // Results of background tasks, applied on the UI goroutine
+	progressMu       sync.Mutex
+	progress         mhtmlparser.Progress
 }
 
 func main() {
@@ -76,10 +114,12 @@ func run(window *app.Window) error {
 		darkMode:         true,
 		filePath:         widget.Editor{ReadOnly: true},
 		rawContent:       widget.Editor{ReadOnly: true},
+		pruneRules:       widget.Editor{SingleLine: true, Submit: true},
 		resources:        []Resource{},
 		checkBoxes:       []widget.Bool{},
 		parser:           mhtmlparser.New("", false),
 		fetchExternalBtn: widget.Bool{Value: true},
+		done:             make(chan func(), 4),
 	}
 	mhtmlApp.setDarkModePalette()
 
@@ -88,6 +128,10 @@ func run(window *app.Window) error {
         e := window.Event()
         switch evt := e.(type) {
         case app.DestroyEvent:
+            if mhtmlApp.cancel != nil {
+                mhtmlApp.cancel()
+            }
+            mhtmlApp.parser.Close()
             return evt.Err
         case app.FrameEvent:
             gtx := app.NewContext(&ops, evt)
@@ -123,6 +167,8 @@ func (a *MHTMLApp) toggleDarkMode() {
 }
 
 func (a *MHTMLApp) Layout(gtx C) D {
+	a.applyTaskResults()
+
 	// Paint the background with theme.Palette.Bg
 	defer clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops).Pop()
 	paint.ColorOp{Color: a.theme.Palette.Bg}.Add(gtx.Ops)
@@ -153,6 +199,15 @@ func (a *MHTMLApp) Layout(gtx C) D {
 		layout.Rigid(func(gtx C) D {
 			return layout.UniformInset(unit.Dp(12)).Layout(gtx, a.actionButtons)
 		}),
+		layout.Rigid(func(gtx C) D {
+			return layout.Inset{Left: unit.Dp(12), Right: unit.Dp(12)}.Layout(gtx, a.pruneRow)
+		}),
+		layout.Rigid(func(gtx C) D {
+			if a.task == "" {
+				return D{}
+			}
+			return layout.UniformInset(unit.Dp(12)).Layout(gtx, a.progressRow)
+		}),
 		layout.Rigid(func(gtx C) D {
 			return layout.UniformInset(unit.Dp(12)).Layout(gtx, func(gtx C) D {
 				return material.Body2(a.theme, "Status: "+a.status).Layout(gtx)
@@ -160,6 +215,39 @@ func (a *MHTMLApp) Layout(gtx C) D {
 		}),
 	)
 }
+
+func (a *MHTMLApp) progressRow(gtx C) D {
+	a.progressMu.Lock()
+	progress := a.progress
+	a.progressMu.Unlock()
+
+	var label string
+	switch progress.Stage {
+	case mhtmlparser.StageFetch:
+		label = fmt.Sprintf("Fetching: %d/%d downloads", progress.Downloads, progress.TotalDownloads)
+	case mhtmlparser.StageWrite:
+		label = fmt.Sprintf("Writing: %d/%d files", progress.Written, progress.TotalWrites)
+	default:
+		label = fmt.Sprintf("Reading: %s of %s, %d parts", formatSize(progress.BytesRead), formatSize(progress.TotalBytes), progress.Parts)
+	}
+
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(1, func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(material.ProgressBar(a.theme, progress.Fraction()).Layout),
+				layout.Rigid(material.Body2(a.theme, label).Layout),
+			)
+		}),
+		layout.Rigid(func(gtx C) D {
+			for a.cancelBtn.Clicked(gtx) {
+				if a.cancel != nil {
+					a.cancel()
+				}
+			}
+			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, material.Button(a.theme, &a.cancelBtn, "✖ Cancel").Layout)
+		}),
+	)
+}
 func (a *MHTMLApp) fileSelectionRow(gtx C) D {
 	return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceStart}.Layout(gtx,
 		layout.Rigid(func(gtx C) D {
@@ -182,7 +270,23 @@ func (a *MHTMLApp) fileSelectionRow(gtx C) D {
 				a.reparseFile()
 			}
 			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx C) D {
-				return material.CheckBox(a.theme, &a.fetchExternalBtn, "Fetch External Scripts").Layout(gtx)
+				return material.CheckBox(a.theme, &a.fetchExternalBtn, "Fetch External Resources").Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			for a.offlineBtn.Update(gtx) {
+				a.reparseFile()
+			}
+			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx C) D {
+				return material.CheckBox(a.theme, &a.offlineBtn, "Offline (Cache Only)").Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			for a.clearCacheBtn.Clicked(gtx) {
+				a.clearCache()
+			}
+			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx C) D {
+				return material.Button(a.theme, &a.clearCacheBtn, "🧹 Clear Cache").Layout(gtx)
 			})
 		}),
 		layout.Rigid(func(gtx C) D {
@@ -203,6 +307,8 @@ func (a *MHTMLApp) rawView(gtx C) D {
 				return material.H6(a.theme, "📄 Raw Source").Layout(gtx)
 			})
 		}),
+		layout.Rigid(a.metadataView),
+		layout.Rigid(a.warningsView),
 		layout.Flexed(1, func(gtx C) D {
 			return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, func(gtx C) D {
 				if a.rawContent.Text() == "" {
@@ -214,7 +320,114 @@ func (a *MHTMLApp) rawView(gtx C) D {
 	)
 }
 
+func (a *MHTMLApp) metadataView(gtx C) D {
+	meta := a.parser.Metadata
+	if meta.URL == "" && meta.Title == "" && meta.Date.IsZero() && meta.Generator == "" {
+		return D{}
+	}
+	saved := "unknown"
+	if !meta.Date.IsZero() {
+		saved = meta.Date.Format("2006-01-02 15:04:05 MST")
+	}
+	lines := []string{
+		"Title: " + meta.Title,
+		"URL: " + meta.URL,
+		fmt.Sprintf("Saved: %s    Generator: %s    MIME-Version: %s", saved, meta.Generator, meta.MIMEVersion),
+	}
+	children := make([]layout.FlexChild, len(lines))
+	for i, line := range lines {
+		children[i] = layout.Rigid(func(gtx C) D {
+			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, material.Body2(a.theme, line).Layout)
+		})
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+// maxShownWarnings limits how many parser warnings are listed above the raw source.
+const maxShownWarnings = 3
+
+func (a *MHTMLApp) warningsView(gtx C) D {
+	warnings := a.parser.Warnings
+	if len(warnings) == 0 {
+		return D{}
+	}
+	lines := []string{fmt.Sprintf("⚠ %d warnings:", len(warnings))}
+	for i, w := range warnings {
+		if i == maxShownWarnings {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(warnings)-maxShownWarnings))
+			break
+		}
+		lines = append(lines, w.Error())
+	}
+	children := make([]layout.FlexChild, len(lines))
+	for i, line := range lines {
+		children[i] = layout.Rigid(func(gtx C) D {
+			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, material.Body2(a.theme, line).Layout)
+		})
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+func (a *MHTMLApp) pruneRow(gtx C) D {
+	for {
+		evt, ok := a.pruneRules.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := evt.(widget.SubmitEvent); ok {
+			a.prune()
+		}
+	}
+	for a.pruneBtn.Clicked(gtx) {
+		a.prune()
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return material.Editor(a.theme, &a.pruneRules, "Prune rules, e.g. host=*.doubleclick.net; type=image/* size>500k").Layout(gtx)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, material.Button(a.theme, &a.pruneBtn, "✂ Prune").Layout)
+				}),
+			)
+		}),
+		layout.Rigid(a.pruneReportView),
+	)
+}
+
+// pruneReportView lists the size of each resource category before and after the last prune.
+func (a *MHTMLApp) pruneReportView(gtx C) D {
+	if a.pruneReport == nil {
+		return D{}
+	}
+	var lines []string
+	for _, c := range a.pruneReport.Categories {
+		if c.PartsBefore == c.PartsAfter && c.BytesBefore == c.BytesAfter {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d → %d parts, %s → %s", c.Name, c.PartsBefore, c.PartsAfter, formatSize(c.BytesBefore), formatSize(c.BytesAfter)))
+	}
+	children := make([]layout.FlexChild, len(lines))
+	for i, line := range lines {
+		children[i] = layout.Rigid(func(gtx C) D {
+			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, material.Body2(a.theme, line).Layout)
+		})
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
 func (a *MHTMLApp) resourcesTable(gtx C) D {
+	// Handle edits before laying out rows, since removing one shifts the rest.
+	for i := range a.replaceBtns {
+		if a.replaceBtns[i].Clicked(gtx) {
+			a.replaceResource(i)
+		}
+		if a.removeBtns[i].Clicked(gtx) {
+			a.removeResource(i)
+			break
+		}
+	}
 	list := &layout.List{Axis: layout.Vertical}
 	return list.Layout(gtx, len(a.resources)+1, func(gtx C, i int) D {
 		if i == 0 {
@@ -244,6 +457,11 @@ func (a *MHTMLApp) resourcesTable(gtx C) D {
 						return material.Label(a.theme, unit.Sp(14), "Source").Layout(gtx)
 					})
 				}),
+				layout.Rigid(func(gtx C) D {
+					return layout.Inset{Left: unit.Dp(16)}.Layout(gtx, func(gtx C) D {
+						return material.Label(a.theme, unit.Sp(14), "Edit").Layout(gtx)
+					})
+				}),
 			)
 		}
 		res := a.resources[i-1]
@@ -279,6 +497,19 @@ func (a *MHTMLApp) resourcesTable(gtx C) D {
 					return material.Label(a.theme, unit.Sp(14), res.Source).Layout(gtx)
 				})
 			}),
+			layout.Rigid(func(gtx C) D {
+				// Only embedded parts are saved back into the archive.
+				if res.Source != "embedded" || i-1 >= len(a.replaceBtns) {
+					return D{}
+				}
+				return layout.Inset{Left: unit.Dp(16)}.Layout(gtx, func(gtx C) D {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(material.Button(a.theme, &a.replaceBtns[i-1], "Replace…").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(4)}.Layout),
+						layout.Rigid(material.Button(a.theme, &a.removeBtns[i-1], "Remove").Layout),
+					)
+				})
+			}),
 		)
 	})
 }
@@ -297,16 +528,48 @@ func (a *MHTMLApp) actionButtons(gtx C) D {
 			}
 			return material.Button(a.theme, &a.extractBtn, "⬇️ Extract Selected").Layout(gtx)
 		}),
+		layout.Rigid(func(gtx C) D {
+			return material.CheckBox(a.theme, &a.mirrorPathsBtn, "Mirror URL Paths").Layout(gtx)
+		}),
+		layout.Rigid(func(gtx C) D {
+			for a.exportSiteBtn.Clicked(gtx) {
+				a.exportSite()
+			}
+			return material.Button(a.theme, &a.exportSiteBtn, "🌐 Export Site").Layout(gtx)
+		}),
+		layout.Rigid(func(gtx C) D {
+			for a.singleFileBtn.Clicked(gtx) {
+				a.exportSingleFile()
+			}
+			return material.Button(a.theme, &a.singleFileBtn, "📄 Single HTML").Layout(gtx)
+		}),
 		layout.Rigid(func(gtx C) D {
 			for a.outputDirBtn.Clicked(gtx) {
 				a.changeOutputDir()
 			}
 			return material.Button(a.theme, &a.outputDirBtn, "🗂 Change Output Dir").Layout(gtx)
 		}),
+		layout.Rigid(func(gtx C) D {
+			for a.metadataBtn.Clicked(gtx) {
+				a.exportMetadata()
+			}
+			return material.Button(a.theme, &a.metadataBtn, "🏷 Export Metadata").Layout(gtx)
+		}),
+		layout.Rigid(func(gtx C) D {
+			for a.saveAsBtn.Clicked(gtx) {
+				a.saveAs()
+			}
+			return material.Button(a.theme, &a.saveAsBtn, "💾 Save As").Layout(gtx)
+		}),
 	)
 }
 
 func (a *MHTMLApp) browseFile() {
+	if a.busy() {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
 	filePath, err := zenity.SelectFile(
 		zenity.Title("Select MHTML File"),
 		zenity.FileFilters{
@@ -334,23 +597,142 @@ func (a *MHTMLApp) browseFile() {
 	}
 }
 
+// busy reports whether a task other than parsing is running. Such tasks still use the current
+// parser and its spilled files, so a new parse must not replace it until they finish.
+func (a *MHTMLApp) busy() bool {
+	return a.task != "" && a.task != "parse"
+}
+
+// clearCache removes every downloaded response from the cache. It waits for running tasks,
+// which may be reading or writing cache files.
+func (a *MHTMLApp) clearCache() {
+	if a.task != "" {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
+	dir := httpCacheDir()
+	if dir == "" {
+		a.status = "There is no download cache"
+		a.window.Invalidate()
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		a.status = fmt.Sprintf("Error clearing the download cache: %v", err)
+	} else {
+		a.status = "Download cache cleared"
+	}
+	a.window.Invalidate()
+}
+
 func (a *MHTMLApp) reparseFile() {
 	if a.selectedFile != "" {
 		a.parseMHTML()
 	}
 }
 
+// startTask runs fn on a background goroutine with a cancellable context, reporting parser
+// progress to the progress bar. The function fn returns is applied on the UI goroutine once
+// fn finishes; current is false if another task has been started since.
+func (a *MHTMLApp) startTask(name string, parser *mhtmlparser.MHTMLParser, fn func(ctx context.Context) func(current bool)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.taskID++
+	id := a.taskID
+	a.task = name
+	a.cancel = cancel
+	a.setProgress(mhtmlparser.Progress{})
+	parser.OnProgress = a.setProgress
+
+	go func() {
+		apply := fn(ctx)
+		a.done <- func() {
+			cancel()
+			current := id == a.taskID
+			if current {
+				a.task = ""
+				a.cancel = nil
+			}
+			apply(current)
+		}
+		a.window.Invalidate()
+	}()
+}
+
+// applyTaskResults applies the results of finished background tasks.
+func (a *MHTMLApp) applyTaskResults() {
+	for {
+		select {
+		case apply := <-a.done:
+			apply()
+		default:
+			return
+		}
+	}
+}
+
+func (a *MHTMLApp) setProgress(progress mhtmlparser.Progress) {
+	a.progressMu.Lock()
+	a.progress = progress
+	a.progressMu.Unlock()
+	a.window.Invalidate()
+}
+
 func (a *MHTMLApp) parseMHTML() {
-	a.parser = mhtmlparser.New(a.selectedFile, a.fetchExternalBtn.Value)
-	if err := a.parser.Parse(); err != nil {
-		a.status = fmt.Sprintf("Error parsing MHTML file: %v", err)
+	if a.busy() {
+		a.status = "Another operation is in progress"
 		a.window.Invalidate()
 		return
 	}
+	fetcher, err := mhtmlparser.NewHTTPFetcher(mhtmlparser.HTTPOptions{
+		CacheDir:     httpCacheDir(),
+		CacheOnly:    a.offlineBtn.Value,
+		CacheMaxSize: httpCacheMaxSize,
+	})
+	if err != nil {
+		a.status = fmt.Sprintf("Error setting up downloads: %v", err)
+		a.window.Invalidate()
+		return
+	}
+	if a.cancel != nil {
+		a.cancel()
+	}
+
+	parser := mhtmlparser.New(a.selectedFile, a.fetchExternalBtn.Value)
+	parser.SpillThreshold = spillThreshold
+	parser.Fetcher = fetcher
+	a.status = "Parsing " + a.selectedFile + "..."
+	a.startTask("parse", parser, func(ctx context.Context) func(bool) {
+		err := parser.ParseContext(ctx)
+		return func(current bool) {
+			if !current || err != nil {
+				parser.Close()
+			}
+			if !current {
+				return
+			}
+			if errors.Is(err, context.Canceled) {
+				a.status = "Parsing canceled"
+			} else if err != nil {
+				a.status = fmt.Sprintf("Error parsing MHTML file: %v", err)
+			} else {
+				a.parser.Close()
+				a.parser = parser
+				a.showParsed()
+			}
+			a.window.Invalidate()
+		}
+	})
+	a.window.Invalidate()
+}
+
+func (a *MHTMLApp) showParsed() {
+	a.pruneReport = nil
 
 	// Populate resources
 	a.resources = make([]Resource, len(a.parser.Resources))
 	a.checkBoxes = make([]widget.Bool, len(a.parser.Resources))
+	a.replaceBtns = make([]widget.Clickable, len(a.parser.Resources))
+	a.removeBtns = make([]widget.Clickable, len(a.parser.Resources))
 	for i, res := range a.parser.Resources {
 		a.resources[i] = Resource{
 			Type:     res.Type,
@@ -367,8 +749,7 @@ func (a *MHTMLApp) parseMHTML() {
 		htmlContent = "[No HTML content found]"
 	}
 	a.rawContent.SetText(htmlContent)
-	a.status = fmt.Sprintf("Loaded: %s (Output directory: %s, %d resources found)", a.selectedFile, a.outputDir, len(a.resources))
-	a.window.Invalidate()
+	a.status = fmt.Sprintf("Loaded: %s (Output directory: %s, %d resources found, %d frames, %d warnings)", a.selectedFile, a.outputDir, len(a.resources), len(a.parser.Frames()), len(a.parser.Warnings))
 }
 
 func (a *MHTMLApp) changeOutputDir() {
@@ -422,6 +803,11 @@ func (a *MHTMLApp) extractSelected() {
 		a.window.Invalidate()
 		return
 	}
+	if a.task != "" {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
 
 	selectedIndices := []int{}
 	for i, res := range a.resources {
@@ -430,17 +816,305 @@ func (a *MHTMLApp) extractSelected() {
 		}
 	}
 
-	paths, err := a.parser.ExtractResources(a.outputDir, selectedIndices)
+	parser, outputDir := a.parser, a.outputDir
+	parser.Layout = mhtmlparser.LayoutFlat
+	if a.mirrorPathsBtn.Value {
+		parser.Layout = mhtmlparser.LayoutMirror
+	}
+	a.status = "Extracting resources..."
+	a.startTask("extract", parser, func(ctx context.Context) func(bool) {
+		paths, err := parser.ExtractResourcesContext(ctx, outputDir, selectedIndices)
+		return func(current bool) {
+			if !current {
+				return
+			}
+			switch {
+			case errors.Is(err, context.Canceled):
+				a.status = fmt.Sprintf("Extraction canceled after %d resources", len(paths))
+			case err != nil:
+				a.status = fmt.Sprintf("Error extracting resources: %v", err)
+			case len(paths) == 0:
+				a.status = "No resources selected for extraction"
+			default:
+				a.status = fmt.Sprintf("Extracted %d resources to %s", len(paths), outputDir)
+			}
+			a.window.Invalidate()
+		}
+	})
+	a.window.Invalidate()
+}
+
+// exportSite writes every resource to the output directory with references rewritten to the
+// local copies, so the saved page can be opened offline.
+func (a *MHTMLApp) exportSite() {
+	if a.selectedFile == "" {
+		a.status = "No MHTML file selected"
+		a.window.Invalidate()
+		return
+	}
+	if a.outputDir == "" {
+		a.status = "No output directory set"
+		a.window.Invalidate()
+		return
+	}
+	if a.task != "" {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
+
+	parser, outputDir := a.parser, a.outputDir
+	parser.Layout = mhtmlparser.LayoutFlat
+	if a.mirrorPathsBtn.Value {
+		parser.Layout = mhtmlparser.LayoutMirror
+	}
+	a.status = "Exporting site..."
+	a.startTask("export", parser, func(ctx context.Context) func(bool) {
+		mainPath, err := parser.ExportSiteContext(ctx, outputDir)
+		return func(current bool) {
+			if !current {
+				return
+			}
+			switch {
+			case errors.Is(err, context.Canceled):
+				a.status = "Site export canceled"
+			case err != nil:
+				a.status = fmt.Sprintf("Error exporting site: %v", err)
+			default:
+				a.status = "Site exported, open " + mainPath
+			}
+			a.window.Invalidate()
+		}
+	})
+	a.window.Invalidate()
+}
+
+// exportSingleFile saves the page as one self-contained HTML file in the output directory,
+// named after the MHTML file.
+func (a *MHTMLApp) exportSingleFile() {
+	if a.selectedFile == "" {
+		a.status = "No MHTML file selected"
+		a.window.Invalidate()
+		return
+	}
+	if a.outputDir == "" {
+		a.status = "No output directory set"
+		a.window.Invalidate()
+		return
+	}
+	if a.task != "" {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
+
+	parser, outputDir := a.parser, a.outputDir
+	baseName := filepath.Base(a.selectedFile)
+	baseName = baseName[:len(baseName)-len(filepath.Ext(baseName))]
+	path := filepath.Join(outputDir, baseName+".html")
+	a.status = "Writing single HTML file..."
+	a.startTask("single", parser, func(ctx context.Context) func(bool) {
+		err := writeSingleFile(ctx, parser, path)
+		return func(current bool) {
+			if !current {
+				return
+			}
+			switch {
+			case errors.Is(err, context.Canceled):
+				a.status = "Single file export canceled"
+			case err != nil:
+				a.status = fmt.Sprintf("Error writing single HTML file: %v", err)
+			default:
+				a.status = "Single HTML file saved to " + path
+			}
+			a.window.Invalidate()
+		}
+	})
+	a.window.Invalidate()
+}
+
+// writeSingleFile writes the self-contained page to path, removing the file again on failure.
+func writeSingleFile(ctx context.Context, parser *mhtmlparser.MHTMLParser, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
 	if err != nil {
-		a.status = fmt.Sprintf("Error extracting resources: %v", err)
+		return err
+	}
+	err = parser.WriteSingleFileContext(ctx, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// replaceResource swaps the data of a part for the contents of a file the user picks.
+func (a *MHTMLApp) replaceResource(index int) {
+	if a.task != "" {
+		a.status = "Another operation is in progress"
 		a.window.Invalidate()
 		return
 	}
+	filePath, err := zenity.SelectFile(zenity.Title("Replace " + a.resources[index].Filename))
+	if err == zenity.ErrCanceled {
+		return
+	}
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(filePath)
+	}
+	if err == nil {
+		err = a.parser.ReplaceData(index, data)
+	}
+	if err != nil {
+		a.status = fmt.Sprintf("Error replacing %s: %v", a.resources[index].Filename, err)
+		a.window.Invalidate()
+		return
+	}
+	a.resources[index].Size = int64(a.parser.Resources[index].Size)
+	a.rawContent.SetText(a.parser.GetHTMLContent())
+	a.status = fmt.Sprintf("Replaced %s with %s, use Save As to write the archive", a.resources[index].Filename, filePath)
+	a.window.Invalidate()
+}
 
-	if len(paths) == 0 {
-		a.status = "No resources selected for extraction"
+// removeResource deletes a part from the archive and its row from the table.
+func (a *MHTMLApp) removeResource(index int) {
+	if a.task != "" {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
+	name := a.resources[index].Filename
+	if err := a.parser.RemoveResource(index); err != nil {
+		a.status = fmt.Sprintf("Error removing %s: %v", name, err)
+		a.window.Invalidate()
+		return
+	}
+	a.resources = append(a.resources[:index], a.resources[index+1:]...)
+	a.checkBoxes = append(a.checkBoxes[:index], a.checkBoxes[index+1:]...)
+	a.replaceBtns = append(a.replaceBtns[:index], a.replaceBtns[index+1:]...)
+	a.removeBtns = append(a.removeBtns[:index], a.removeBtns[index+1:]...)
+	a.status = fmt.Sprintf("Removed %s, use Save As to write the archive", name)
+	a.window.Invalidate()
+}
+
+// saveAs writes the edited archive to a file the user picks.
+func (a *MHTMLApp) saveAs() {
+	if a.selectedFile == "" {
+		a.status = "No MHTML file selected"
+		a.window.Invalidate()
+		return
+	}
+	if a.task != "" {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
+	path, err := zenity.SelectFileSave(
+		zenity.Title("Save MHTML As"),
+		zenity.Filename(a.selectedFile),
+		zenity.ConfirmOverwrite(),
+		zenity.FileFilters{
+			{Name: "MHTML Files", Patterns: []string{"*.mhtml", "*.mht"}, CaseFold: true},
+		},
+	)
+	if err == zenity.ErrCanceled {
+		a.status = "Save canceled"
+		a.window.Invalidate()
+		return
+	}
+	if err != nil {
+		a.status = fmt.Sprintf("Error selecting file: %v", err)
+		a.window.Invalidate()
+		return
+	}
+
+	parser := a.parser
+	a.status = "Saving archive..."
+	a.startTask("save", parser, func(ctx context.Context) func(bool) {
+		err := parser.SaveFile(path)
+		return func(current bool) {
+			if !current {
+				return
+			}
+			if err != nil {
+				a.status = fmt.Sprintf("Error saving archive: %v", err)
+			} else {
+				a.status = "Archive saved to " + path
+			}
+			a.window.Invalidate()
+		}
+	})
+	a.window.Invalidate()
+}
+
+// prune drops the resources matched by the rules in the prune editor and shows the savings.
+func (a *MHTMLApp) prune() {
+	if a.selectedFile == "" {
+		a.status = "No MHTML file selected"
+		a.window.Invalidate()
+		return
+	}
+	if a.task != "" {
+		a.status = "Another operation is in progress"
+		a.window.Invalidate()
+		return
+	}
+	rules, err := mhtmlparser.ParsePruneRules(a.pruneRules.Text())
+	if err == nil && len(rules) == 0 {
+		err = errors.New("no rules given")
+	}
+	var report mhtmlparser.PruneReport
+	if err == nil {
+		report, err = a.parser.Prune(rules)
+	}
+	if err != nil {
+		a.status = fmt.Sprintf("Error pruning: %v", err)
+		a.window.Invalidate()
+		return
+	}
+
+	a.showParsed()
+	a.pruneReport = &report
+	var before, after int64
+	for _, c := range report.Categories {
+		before += c.BytesBefore
+		after += c.BytesAfter
+	}
+	a.status = fmt.Sprintf("Pruned %d resources (%s → %s), use Save As to write the archive", len(report.Removed), formatSize(before), formatSize(after))
+	a.window.Invalidate()
+}
+
+func (a *MHTMLApp) exportMetadata() {
+	if a.selectedFile == "" {
+		a.status = "No MHTML file selected"
+		a.window.Invalidate()
+		return
+	}
+	if err := os.MkdirAll(a.outputDir, 0755); err != nil {
+		a.status = fmt.Sprintf("Error creating output directory: %v", err)
+		a.window.Invalidate()
+		return
+	}
+	path := filepath.Join(a.outputDir, "metadata.json")
+	file, err := os.Create(path)
+	if err != nil {
+		a.status = fmt.Sprintf("Error exporting metadata: %v", err)
+		a.window.Invalidate()
+		return
+	}
+	err = a.parser.WriteMetadata(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		a.status = fmt.Sprintf("Error exporting metadata: %v", err)
 	} else {
-		a.status = fmt.Sprintf("Extracted %d resources to %s", len(paths), a.outputDir)
+		a.status = "Metadata exported to " + path
 	}
 	a.window.Invalidate()
 }
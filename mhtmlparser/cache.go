@@ -0,0 +1,392 @@
+package mhtmlparser
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedHeaders are the response headers kept in the cache, the others being specific to
+// the connection or the moment of the request.
+var cachedHeaders = []string{"Content-Type", "Content-Encoding", "Content-Language", "ETag", "Last-Modified", "Cache-Control", "Expires", "Date", "Vary", "Age"}
+
+// copyCachedHeaders copies the cachedHeaders present in src to dst.
+func copyCachedHeaders(dst, src http.Header) {
+	for _, key := range cachedHeaders {
+		if values := src.Values(key); len(values) > 0 {
+			dst[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+}
+
+// cacheEntry is the first line of a cache file; the response body follows it.
+type cacheEntry struct {
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Stored time.Time   `json:"stored"` // When the response was received or last revalidated
+}
+
+// cacheTransport is an http.RoundTripper keeping successful GET responses in dir, one file
+// per URL, so they can be reused across parses and processes. Fresh entries are served
+// without a request, as Cache-Control, Expires or Last-Modified allow; stale ones are
+// revalidated with If-None-Match and If-Modified-Since. In offline mode the network is never
+// used and uncached URLs fail with ErrNotCached. With a maxSize, the least recently used
+// entries are evicted once dir holds more than maxSize bytes.
+type cacheTransport struct {
+	dir     string
+	offline bool
+	maxSize int64 // 0 for no limit
+	next    http.RoundTripper
+
+	mu      sync.Mutex
+	size    int64 // Bytes in dir as far as known, valid once counted
+	counted bool
+}
+
+func newCacheTransport(dir string, offline bool, maxSize int64, next http.RoundTripper) *cacheTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &cacheTransport{dir: dir, offline: offline, maxSize: maxSize, next: next}
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		if t.offline {
+			return nil, fmt.Errorf("%w: %s %s", ErrNotCached, req.Method, req.URL)
+		}
+		return t.next.RoundTrip(req)
+	}
+
+	path := t.path(req.URL.String())
+	entry, body, err := readCacheEntry(path)
+	cached := err == nil
+	switch {
+	case t.offline && !cached:
+		return nil, fmt.Errorf("%w: %s", ErrNotCached, req.URL)
+	case t.offline, cached && entry.fresh(time.Now()):
+		t.touch(path)
+		return entry.response(req, body), nil
+	}
+
+	if cached {
+		if !entry.revalidatable() {
+			body.Close()
+			cached = false
+		} else {
+			req = req.Clone(req.Context())
+			if etag := entry.Header.Get("ETag"); etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if modified := entry.Header.Get("Last-Modified"); modified != "" {
+				req.Header.Set("If-Modified-Since", modified)
+			}
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		if cached {
+			body.Close()
+		}
+		return nil, err
+	}
+
+	if cached && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		// The Age of the stored response no longer applies once it has been revalidated.
+		entry.Header.Del("Age")
+		copyCachedHeaders(entry.Header, resp.Header)
+		entry.Stored = time.Now()
+		// Refreshing the stored headers is best effort; the cached body is still valid.
+		if refreshed, err := t.rewrite(path, entry, body); err == nil {
+			body = refreshed
+		} else if body, err = reopenCacheBody(path); err != nil {
+			return nil, err
+		}
+		return entry.response(req, body), nil
+	}
+	if cached {
+		body.Close()
+	}
+	if resp.StatusCode != http.StatusOK || !storable(resp.Header) {
+		return resp, nil
+	}
+
+	entry = cacheEntry{URL: req.URL.String(), Header: make(http.Header), Stored: time.Now()}
+	copyCachedHeaders(entry.Header, resp.Header)
+	file, err := t.create(path, entry)
+	if err != nil {
+		// The response is still usable without the cache.
+		return resp, nil
+	}
+	resp.Body = &cachingBody{body: resp.Body, file: file, path: path, t: t}
+	return resp, nil
+}
+
+// path returns the cache file for url.
+func (t *cacheTransport) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(t.dir, key[:2], key)
+}
+
+// create opens a temporary file next to path holding entry, ready for the body.
+func (t *cacheTransport) create(path string, entry cacheEntry) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(file).Encode(entry); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// rewrite replaces the cache file at path with entry and the rest of body, and returns the
+// new file's body.
+func (t *cacheTransport) rewrite(path string, entry cacheEntry, body io.ReadCloser) (io.ReadCloser, error) {
+	defer body.Close()
+	file, err := t.create(path, entry)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	t.stored(path)
+	return reopenCacheBody(path)
+}
+
+// touch marks the cache file at path as used now, for eviction.
+func (t *cacheTransport) touch(path string) {
+	if t.maxSize > 0 {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+}
+
+// stored accounts for the cache file just written at path, evicting the least recently
+// used entries once the cache holds more than maxSize bytes.
+func (t *cacheTransport) stored(path string) {
+	if t.maxSize <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counted {
+		// Replaced entries are counted twice until the next eviction recounts.
+		if info, err := os.Stat(path); err == nil {
+			t.size += info.Size()
+		}
+	} else {
+		t.size, t.counted = t.evict(math.MaxInt64), true
+	}
+	if t.size > t.maxSize {
+		// Evict to 90% so that the next responses do not each trigger a scan.
+		t.size = t.evict(t.maxSize / 10 * 9)
+	}
+}
+
+// evict removes the least recently used cache files until at most limit bytes remain, and
+// returns the bytes remaining. Temporary files of responses being written are left alone.
+func (t *cacheTransport) evict(limit int64) int64 {
+	type cacheFile struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cacheFile
+	var total int64
+	filepath.WalkDir(t.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, cacheFile{path, info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	slices.SortFunc(files, func(a, b cacheFile) int { return a.used.Compare(b.used) })
+	for _, f := range files {
+		if total <= limit {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+	return total
+}
+
+// readCacheEntry opens the cache file at path and returns its entry and body.
+func readCacheEntry(path string) (cacheEntry, io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return cacheEntry{}, nil, err
+	}
+	r := bufio.NewReader(file)
+	line, err := r.ReadBytes('\n')
+	var entry cacheEntry
+	if err == nil {
+		err = json.Unmarshal(line, &entry)
+	}
+	if err != nil {
+		file.Close()
+		return cacheEntry{}, nil, fmt.Errorf("corrupt cache file %s: %w", path, err)
+	}
+	if entry.Header == nil {
+		entry.Header = make(http.Header)
+	}
+	return entry, struct {
+		io.Reader
+		io.Closer
+	}{r, file}, nil
+}
+
+// reopenCacheBody returns the body of the cache file at path.
+func reopenCacheBody(path string) (io.ReadCloser, error) {
+	_, body, err := readCacheEntry(path)
+	return body, err
+}
+
+// response builds a 200 response to req from the entry.
+func (e cacheEntry) response(req *http.Request, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          body,
+		ContentLength: -1,
+		Request:       req,
+	}
+}
+
+// fresh reports whether the entry may be used at now without revalidation, following the
+// expiration rules of RFC 9111: max-age, then Expires, then a tenth of the time since
+// Last-Modified.
+func (e cacheEntry) fresh(now time.Time) bool {
+	directives := cacheControl(e.Header)
+	if _, ok := directives["no-cache"]; ok {
+		return false
+	}
+	// The current age adds the time spent in caches before the response was received.
+	age := now.Sub(e.Stored)
+	if seconds, err := strconv.ParseInt(strings.TrimSpace(e.Header.Get("Age")), 10, 64); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		return err == nil && age < time.Duration(seconds)*time.Second
+	}
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.Stored
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		return err == nil && age < t.Sub(date)
+	}
+	if modified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && modified.Before(date) {
+		return age < date.Sub(modified)/10
+	}
+	return false
+}
+
+// revalidatable reports whether a stale entry can be checked with a conditional request.
+func (e cacheEntry) revalidatable() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// storable reports whether a response may be kept in the cache.
+func storable(header http.Header) bool {
+	_, noStore := cacheControl(header)["no-store"]
+	return !noStore && header.Get("Vary") != "*"
+}
+
+// cacheControl parses the Cache-Control directives of header into a map from lowercase
+// directive name to its value, which is empty for directives without one.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// cachingBody copies a response body into a cache file while it is read. The file replaces
+// the cache entry only once the body has been read to the end.
+type cachingBody struct {
+	body   io.ReadCloser
+	file   *os.File // nil once committed or abandoned
+	path   string
+	failed bool
+	t      *cacheTransport
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.file != nil && n > 0 && !b.failed {
+		if _, werr := b.file.Write(p[:n]); werr != nil {
+			b.failed = true
+		}
+	}
+	if err == io.EOF && b.file != nil {
+		b.finish(!b.failed)
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	if b.file != nil {
+		b.finish(false)
+	}
+	return b.body.Close()
+}
+
+// finish moves the cache file into place when commit is set, and removes it otherwise.
+func (b *cachingBody) finish(commit bool) {
+	name := b.file.Name()
+	err := b.file.Close()
+	b.file = nil
+	if commit && err == nil && os.Rename(name, b.path) == nil {
+		b.t.stored(b.path)
+		return
+	}
+	os.Remove(name)
+}
@@ -0,0 +1,271 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheEntryFresh(t *testing.T) {
+	stored := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	date := stored.Format(http.TimeFormat)
+	tests := []struct {
+		name   string
+		header http.Header
+		after  time.Duration // Time since the response was stored
+		fresh  bool
+	}{
+		{"max-age fresh", http.Header{"Cache-Control": {"max-age=60"}}, 59 * time.Second, true},
+		{"max-age stale", http.Header{"Cache-Control": {"max-age=60"}}, 61 * time.Second, false},
+		{"max-age with Age", http.Header{"Cache-Control": {"public, max-age=3600"}, "Age": {"3500"}}, 50 * time.Second, true},
+		{"max-age exhausted by Age", http.Header{"Cache-Control": {"public, max-age=3600"}, "Age": {"3500"}}, 101 * time.Second, false},
+		{"max-age over Expires", http.Header{"Cache-Control": {"max-age=10"}, "Date": {date}, "Expires": {stored.Add(time.Hour).Format(http.TimeFormat)}}, time.Minute, false},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=60"}}, 0, false},
+		{"Expires fresh", http.Header{"Date": {date}, "Expires": {stored.Add(time.Hour).Format(http.TimeFormat)}}, 59 * time.Minute, true},
+		{"Expires stale", http.Header{"Date": {date}, "Expires": {stored.Add(time.Hour).Format(http.TimeFormat)}}, 61 * time.Minute, false},
+		{"Expires with Age", http.Header{"Date": {date}, "Expires": {stored.Add(time.Hour).Format(http.TimeFormat)}, "Age": {"1800"}}, 31 * time.Minute, false},
+		{"invalid Expires", http.Header{"Date": {date}, "Expires": {"0"}}, 0, false},
+		{"Last-Modified heuristic fresh", http.Header{"Date": {date}, "Last-Modified": {stored.Add(-100 * time.Hour).Format(http.TimeFormat)}}, 9 * time.Hour, true},
+		{"Last-Modified heuristic stale", http.Header{"Date": {date}, "Last-Modified": {stored.Add(-100 * time.Hour).Format(http.TimeFormat)}}, 11 * time.Hour, false},
+		{"no freshness information", http.Header{"Date": {date}}, 0, false},
+	}
+	for _, tt := range tests {
+		e := cacheEntry{Header: tt.header, Stored: stored}
+		if got := e.fresh(stored.Add(tt.after)); got != tt.fresh {
+			t.Errorf("%s: fresh = %v, want %v", tt.name, got, tt.fresh)
+		}
+	}
+}
+
+// cacheServer serves the handler and counts the requests it receives.
+func cacheServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// cachedGet fetches url with f and returns the body.
+func cachedGet(t *testing.T, f Fetcher, url string) string {
+	t.Helper()
+	resp, err := f.Fetch(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Fetch(%s): %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", url, err)
+	}
+	return string(body)
+}
+
+// cacheFiles returns the committed cache files in dir.
+func cacheFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && !strings.HasPrefix(d.Name(), ".tmp-") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+func newCachingFetcher(t *testing.T, opts HTTPOptions) *HTTPFetcher {
+	t.Helper()
+	f, err := NewHTTPFetcher(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCacheServesFreshResponses(t *testing.T) {
+	srv, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "body of "+r.URL.Path)
+	})
+	f := newCachingFetcher(t, HTTPOptions{CacheDir: t.TempDir()})
+	for i := 0; i < 3; i++ {
+		if body := cachedGet(t, f, srv.URL+"/a.css"); body != "body of /a.css" {
+			t.Fatalf("body = %q", body)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("server received %d requests, want 1", n)
+	}
+}
+
+func TestCacheRevalidates(t *testing.T) {
+	var revalidated atomic.Int32
+	srv, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidated.Add(1)
+			// The refreshed headers make the entry fresh for a minute.
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/css")
+		io.WriteString(w, "body{}")
+	})
+	f := newCachingFetcher(t, HTTPOptions{CacheDir: t.TempDir()})
+	for i := 0; i < 3; i++ {
+		if body := cachedGet(t, f, srv.URL+"/a.css"); body != "body{}" {
+			t.Fatalf("request %d: body = %q", i, body)
+		}
+	}
+	if n, r := hits.Load(), revalidated.Load(); n != 2 || r != 1 {
+		t.Errorf("server received %d requests with %d revalidations, want 2 and 1", n, r)
+	}
+	resp, err := f.Fetch(context.Background(), srv.URL+"/a.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct, cc := resp.Header.Get("Content-Type"), resp.Header.Get("Cache-Control"); ct != "text/css" || cc != "max-age=60" {
+		t.Errorf("cached headers Content-Type %q, Cache-Control %q", ct, cc)
+	}
+}
+
+func TestCacheDoesNotStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"no-store", http.Header{"Cache-Control": {"no-store, max-age=60"}}, http.StatusOK},
+		{"Vary *", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, http.StatusOK},
+		{"not found", http.Header{"Cache-Control": {"max-age=60"}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+				for key, values := range tt.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, "body")
+			})
+			dir := t.TempDir()
+			f := newCachingFetcher(t, HTTPOptions{CacheDir: dir})
+			cachedGet(t, f, srv.URL+"/a")
+			cachedGet(t, f, srv.URL+"/a")
+			if n := hits.Load(); n != 2 {
+				t.Errorf("server received %d requests, want 2", n)
+			}
+			if files := cacheFiles(t, dir); len(files) != 0 {
+				t.Errorf("cache files %v", files)
+			}
+		})
+	}
+}
+
+func TestCacheCommitsOnlyComplete(t *testing.T) {
+	srv, _ := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, strings.Repeat("x", 100))
+	})
+	dir := t.TempDir()
+	f := newCachingFetcher(t, HTTPOptions{CacheDir: dir})
+
+	// A body closed before its end, as fetch does past MaxResponseSize.
+	resp, err := f.Fetch(context.Background(), srv.URL+"/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(io.LimitReader(resp.Body, 11))
+	resp.Body.Close()
+	if files := cacheFiles(t, dir); len(files) != 0 {
+		t.Errorf("partial body committed: %v", files)
+	}
+
+	p := New("", true)
+	p.MaxResponseSize = 10
+	p.Fetcher = f
+	if _, _, err := p.fetch(context.Background(), srv.URL+"/big.bin", newFetchBudget(0)); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("fetch error = %v, want ErrResponseTooLarge", err)
+	}
+	if files := cacheFiles(t, dir); len(files) != 0 {
+		t.Errorf("oversized body committed: %v", files)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, shard := range entries {
+		temps, _ := os.ReadDir(filepath.Join(dir, shard.Name()))
+		if len(temps) != 0 {
+			t.Errorf("temporary files left in %s", shard.Name())
+		}
+	}
+}
+
+func TestCacheOnly(t *testing.T) {
+	srv, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, "cached")
+	})
+	dir := t.TempDir()
+	cachedGet(t, newCachingFetcher(t, HTTPOptions{CacheDir: dir}), srv.URL+"/a.js")
+
+	offline := newCachingFetcher(t, HTTPOptions{CacheDir: dir, CacheOnly: true})
+	// Stale entries are served as they are, without revalidation.
+	if body := cachedGet(t, offline, srv.URL+"/a.js"); body != "cached" {
+		t.Errorf("body = %q, want cached", body)
+	}
+	if _, err := offline.Fetch(context.Background(), srv.URL+"/other.js"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("uncached error = %v, want ErrNotCached", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("server received %d requests, want 1", n)
+	}
+	if _, err := NewHTTPFetcher(HTTPOptions{CacheOnly: true}); err == nil {
+		t.Error("CacheOnly without CacheDir accepted")
+	}
+}
+
+func TestCacheMaxSizeEvictsLeastRecentlyUsed(t *testing.T) {
+	srv, hits := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=600")
+		io.WriteString(w, strings.Repeat("x", 10000))
+	})
+	dir := t.TempDir()
+	// Room for two entries and their headers, not three.
+	f := newCachingFetcher(t, HTTPOptions{CacheDir: dir, CacheMaxSize: 25000})
+	get := func(path string) {
+		cachedGet(t, f, srv.URL+path)
+		// Modification times order the entries; keep them apart on coarse filesystems.
+		time.Sleep(20 * time.Millisecond)
+	}
+	get("/a")
+	get("/b")
+	get("/a") // Served from the cache, and now more recently used than /b
+	get("/c") // Past the limit: /b is evicted
+	if n := hits.Load(); n != 3 {
+		t.Fatalf("server received %d requests, want 3", n)
+	}
+	get("/a")
+	get("/c")
+	if n := hits.Load(); n != 3 {
+		t.Errorf("recently used entries were evicted: %d requests, want 3", n)
+	}
+	get("/b")
+	if n := hits.Load(); n != 4 {
+		t.Errorf("least recently used entry kept: %d requests, want 4", n)
+	}
+}
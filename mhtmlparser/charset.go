@@ -0,0 +1,118 @@
+package mhtmlparser
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var (
+	cssCharsetRe  = regexp.MustCompile(`^@charset\s+["']([\w.:-]+)["']\s*;`)
+	metaCharsetRe = regexp.MustCompile(`(?i)(<meta\s[^>]*?charset\s*=\s*["']?)([\w.:-]+)`)
+	utf8BOM       = []byte{0xEF, 0xBB, 0xBF}
+)
+
+// isTextType reports whether a normalized content type carries character data.
+func isTextType(contentType string) bool {
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	switch contentType {
+	case "application/javascript", "application/x-javascript", "application/ecmascript",
+		"application/json", "application/ld+json", "application/xml", "application/xhtml+xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// detectCharset determines the charset of a text part from its BOM, its Content-Type charset parameter,
+// and for HTML and CSS the in-document declaration. It returns "" when the charset is unknown.
+func detectCharset(data []byte, rawContentType, contentType string) string {
+	if contentType == "text/html" || contentType == "application/xhtml+xml" {
+		// DetermineEncoding honors the BOM, the charset parameter and <meta charset> in that order.
+		_, name, certain := charset.DetermineEncoding(data, rawContentType)
+		// Without any declaration it only looks at the first 1024 bytes and guesses windows-1252
+		// when they are ASCII, so check the whole document for UTF-8 first.
+		if !certain && !metaCharsetRe.Match(data[:min(len(data), 1024)]) && utf8.Valid(data) {
+			return "utf-8"
+		}
+		return name
+	}
+	if bytes.HasPrefix(data, utf8BOM) {
+		return "utf-8"
+	}
+	if _, params, err := mime.ParseMediaType(rawContentType); err == nil && params["charset"] != "" {
+		if _, name := charset.Lookup(params["charset"]); name != "" {
+			return name
+		}
+	}
+	if contentType == "text/css" {
+		if m := cssCharsetRe.FindSubmatch(data); m != nil {
+			if _, name := charset.Lookup(string(m[1])); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// textDecoder detects the charset of a text part from its leading bytes. It returns the decoder that
+// transcodes the part to UTF-8, or nil when the part is already UTF-8, not text, or in an unknown charset.
+func textDecoder(prefix []byte, rawContentType, contentType string) (*encoding.Decoder, string, error) {
+	if !isTextType(contentType) {
+		return nil, "", nil
+	}
+	name := detectCharset(prefix, rawContentType, contentType)
+	if name == "" || name == "utf-8" {
+		return nil, name, nil
+	}
+	enc, _ := charset.Lookup(name)
+	if enc == nil {
+		return nil, name, fmt.Errorf("%w %q", ErrUnsupportedCharset, name)
+	}
+	return enc.NewDecoder(), name, nil
+}
+
+// decodeCharset transcodes a text part to UTF-8. It returns the UTF-8 data and the charset
+// the part was stored in. Non-text parts and parts in an unknown charset are returned unchanged.
+func decodeCharset(data []byte, rawContentType, contentType string) ([]byte, string, error) {
+	dec, name, err := textDecoder(data, rawContentType, contentType)
+	if dec == nil {
+		return data, name, err
+	}
+	decoded, err := dec.Bytes(data)
+	if err != nil {
+		return data, name, fmt.Errorf("failed to decode %s text: %w", name, err)
+	}
+	return fixCharsetDeclaration(decoded, contentType), name, nil
+}
+
+// fixCharsetDeclaration points the in-document charset declaration at UTF-8 once the text
+// has been transcoded, so extracted pages and stylesheets render correctly.
+func fixCharsetDeclaration(data []byte, contentType string) []byte {
+	switch contentType {
+	case "text/html":
+		return replaceFirst(metaCharsetRe, data, "${1}utf-8")
+	case "text/css":
+		return replaceFirst(cssCharsetRe, data, `@charset "utf-8";`)
+	}
+	return data
+}
+
+// replaceFirst replaces the first match of re in data with repl, expanding submatch references.
+func replaceFirst(re *regexp.Regexp, data []byte, repl string) []byte {
+	m := re.FindSubmatchIndex(data)
+	if m == nil {
+		return data
+	}
+	var out []byte
+	out = append(out, data[:m[0]]...)
+	out = re.Expand(out, []byte(repl), data, m)
+	return append(out, data[m[1]:]...)
+}
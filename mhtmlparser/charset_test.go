@@ -0,0 +1,36 @@
+package mhtmlparser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeCharsetHTML(t *testing.T) {
+	padding := "<p>" + strings.Repeat("a", 1100) + "</p>"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		wantCharset string
+	}{
+		{name: "undeclared UTF-8 after 1 KB of ASCII", contentType: "text/html", body: padding + "café", want: padding + "café", wantCharset: "utf-8"},
+		{name: "undeclared windows-1252", contentType: "text/html", body: padding + "caf\xe9", want: padding + "café", wantCharset: "windows-1252"},
+		{name: "charset parameter", contentType: "text/html; charset=iso-8859-1", body: "caf\xe9", want: "café", wantCharset: "windows-1252"},
+		{name: "meta charset", contentType: "text/html", body: `<meta charset="windows-1252">caf` + "\xe9", want: `<meta charset="utf-8">café`, wantCharset: "windows-1252"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, name, err := decodeCharset([]byte(tt.body), tt.contentType, "text/html")
+			if err != nil {
+				t.Fatalf("decodeCharset: %v", err)
+			}
+			if name != tt.wantCharset {
+				t.Errorf("charset = %q, want %q", name, tt.wantCharset)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decoded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
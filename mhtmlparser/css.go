@@ -0,0 +1,302 @@
+package mhtmlparser
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Kinds of CSS reference reported in CSSReference.Kind.
+const (
+	CSSURL      = "url"       // A url() value in a declaration
+	CSSImport   = "import"    // The stylesheet of an @import rule
+	CSSFontFace = "font-face" // A url() in the src descriptor of an @font-face rule
+)
+
+// Places a CSSReference can be found, reported in CSSReference.Origin.
+const (
+	OriginStylesheet = "stylesheet" // An embedded text/css part
+	OriginStyleBlock = "style"      // A <style> element of an HTML document
+	OriginStyleAttr  = "attribute"  // A style attribute of an HTML element
+)
+
+// CSSReference is a URL referenced from CSS, resolved against its stylesheet.
+type CSSReference struct {
+	Kind     string    // CSSURL, CSSImport or CSSFontFace
+	Origin   string    // OriginStylesheet, OriginStyleBlock or OriginStyleAttr
+	Section  string    // Section of the CSS part or HTML document holding the reference
+	Ref      string    // The reference as written
+	URL      string    // The reference resolved against the stylesheet's Content-Location
+	Resource *Resource // The archived resource it points to, or nil if missing
+}
+
+// Missing reports whether the referenced resource is not in the archive.
+func (r CSSReference) Missing() bool {
+	return r.Resource == nil
+}
+
+// CSSReferences scans every embedded stylesheet, and the <style> blocks and style attributes
+// of every HTML document, for url() values, @import rules and @font-face sources, and links
+// each to the resource it points to. References to data: URIs and to fragments of the same
+// document, such as url(#clip), are not reported.
+func (p *MHTMLParser) CSSReferences() []CSSReference {
+	var refs []CSSReference
+	add := func(css, base, origin, section string) {
+		for _, ref := range scanCSS(css) {
+			value := strings.TrimSpace(ref.Ref)
+			if value == "" || strings.HasPrefix(value, "#") || strings.HasPrefix(strings.ToLower(value), "data:") {
+				continue
+			}
+			resolved := value
+			if !strings.HasPrefix(strings.ToLower(value), "cid:") {
+				resolved = resolveURL(base, value)
+			}
+			refs = append(refs, CSSReference{
+				Kind:     ref.Kind,
+				Origin:   origin,
+				Section:  section,
+				Ref:      ref.Ref,
+				URL:      resolved,
+				Resource: p.LookupFrom(value, base),
+			})
+		}
+	}
+
+	for _, res := range p.Resources {
+		if res.Source != "embedded" || res.Type != "text/css" {
+			continue
+		}
+		data, err := res.readAll()
+		if err != nil {
+			p.warn(Warning{Part: -1, Section: res.Section, Stage: StageRead, URL: res.URL, Err: err})
+			continue
+		}
+		add(string(data), res.URL, OriginStylesheet, res.Section)
+	}
+	for _, document := range p.Documents {
+		base := document.URL
+		if base == "" {
+			base = p.BaseURL
+		}
+		doc, base, err := parseHTMLDocument(document.Content, base)
+		if err != nil {
+			continue
+		}
+		doc.Find("style").Each(func(_ int, s *goquery.Selection) {
+			add(s.Text(), base, OriginStyleBlock, document.Section)
+		})
+		doc.Find("[style]").Each(func(_ int, s *goquery.Selection) {
+			style, _ := s.Attr("style")
+			add(style, base, OriginStyleAttr, document.Section)
+		})
+	}
+	return refs
+}
+
+// cssRef is a reference found by scanCSS. Start and End delimit Ref within the scanned text.
+type cssRef struct {
+	Kind       string
+	Ref        string
+	Start, End int
+}
+
+// scanCSS finds the url() values, @import rules and @font-face sources of a stylesheet or
+// style attribute. Comments and strings outside of those are skipped, so text like
+// content: "url(x)" is not mistaken for a reference.
+func scanCSS(css string) []cssRef {
+	var refs []cssRef
+	depth := 0
+	fontFaceDepth := -1 // Brace depth inside the current @font-face block, or -1
+	pendingFontFace := false
+	for i := 0; i < len(css); {
+		switch c := css[i]; {
+		case strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return refs
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			i = skipCSSString(css, i)
+		case c == '{':
+			depth++
+			if pendingFontFace {
+				fontFaceDepth, pendingFontFace = depth, false
+			}
+			i++
+		case c == '}':
+			if depth == fontFaceDepth {
+				fontFaceDepth = -1
+			}
+			depth--
+			i++
+		case c == ';':
+			pendingFontFace = false
+			i++
+		case c == '@' && hasPrefixFold(css[i:], "@font-face"):
+			pendingFontFace = true
+			i += len("@font-face")
+		case c == '@' && hasPrefixFold(css[i:], "@import"):
+			j := skipCSSSpace(css, i+len("@import"))
+			if j < len(css) && (css[j] == '"' || css[j] == '\'') {
+				end := skipCSSString(css, j)
+				if end <= len(css) && end > j+2 && css[end-1] == css[j] {
+					refs = append(refs, cssRef{Kind: CSSImport, Ref: css[j+1 : end-1], Start: j + 1, End: end - 1})
+				}
+				i = end
+			} else if ref, end, ok := scanCSSURL(css, j); ok {
+				ref.Kind = CSSImport
+				refs = append(refs, ref)
+				i = end
+			} else {
+				i = j
+			}
+		case (c == 'u' || c == 'U') && hasPrefixFold(css[i:], "url(") && (i == 0 || !isCSSNameByte(css[i-1])):
+			ref, end, ok := scanCSSURL(css, i)
+			if !ok {
+				i += len("url(")
+				continue
+			}
+			ref.Kind = CSSURL
+			if fontFaceDepth > 0 && depth >= fontFaceDepth {
+				ref.Kind = CSSFontFace
+			}
+			refs = append(refs, ref)
+			i = end
+		default:
+			i++
+		}
+	}
+	return refs
+}
+
+// scanCSSURL reads a url(...) token starting at i. It returns the reference, the offset just
+// past the closing parenthesis, and false if there is no well-formed url() at i. An empty
+// url(), which CSS resolves to about:invalid, is not a reference.
+func scanCSSURL(css string, i int) (cssRef, int, bool) {
+	if !hasPrefixFold(css[i:], "url(") {
+		return cssRef{}, i, false
+	}
+	j := skipCSSSpace(css, i+len("url("))
+	var ref cssRef
+	if j < len(css) && (css[j] == '"' || css[j] == '\'') {
+		end := skipCSSString(css, j)
+		if end > len(css) || end == j+1 || css[end-1] != css[j] {
+			return cssRef{}, i, false
+		}
+		ref = cssRef{Ref: css[j+1 : end-1], Start: j + 1, End: end - 1}
+		j = skipCSSSpace(css, end)
+	} else {
+		end := strings.IndexByte(css[j:], ')')
+		if end < 0 {
+			return cssRef{}, i, false
+		}
+		value := strings.TrimRight(css[j:j+end], " \t\r\n\f")
+		ref = cssRef{Ref: value, Start: j, End: j + len(value)}
+		j += end
+	}
+	if j >= len(css) || css[j] != ')' || ref.Ref == "" {
+		return cssRef{}, i, false
+	}
+	return ref, j + 1, true
+}
+
+// skipCSSString returns the offset just past the string starting with the quote at i.
+// An unterminated string runs to the end of the line, as in CSS.
+func skipCSSString(css string, i int) int {
+	quote := css[i]
+	for j := i + 1; j < len(css); j++ {
+		switch css[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		case '\n':
+			return j
+		}
+	}
+	return len(css)
+}
+
+// skipCSSSpace returns the offset of the first non-whitespace byte at or after i.
+func skipCSSSpace(css string, i int) int {
+	for i < len(css) && strings.IndexByte(" \t\r\n\f", css[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// isCSSNameByte reports whether c can be part of a CSS identifier, so that a function
+// like "myurl(" is not read as url().
+func isCSSNameByte(c byte) bool {
+	return c == '-' || c == '_' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// hasPrefixFold reports whether s begins with the ASCII prefix, ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// rewriteCSSRefs calls fn for every url() and @import reference in css and replaces the
+// reference with its result. Returning the reference unchanged leaves that token untouched.
+func rewriteCSSRefs(css string, fn func(ref string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, ref := range scanCSS(css) {
+		if repl := fn(ref.Ref); repl != ref.Ref {
+			b.WriteString(css[last:ref.Start])
+			b.WriteString(repl)
+			last = ref.End
+		}
+	}
+	if last == 0 {
+		return css
+	}
+	b.WriteString(css[last:])
+	return b.String()
+}
+
+// rewriteSrcset calls fn for every candidate URL of a srcset attribute and rebuilds the
+// attribute from the results, keeping each candidate's width or density descriptor.
+func rewriteSrcset(srcset string, fn func(ref string) string) string {
+	var candidates []string
+	s := srcset
+	for {
+		s = strings.TrimLeft(s, " \t\r\n\f,")
+		if s == "" {
+			break
+		}
+		end := strings.IndexAny(s, " \t\r\n\f")
+		if end < 0 {
+			end = len(s)
+		}
+		ref, rest := s[:end], s[end:]
+
+		// A URL directly followed by a comma has no descriptor.
+		var descriptor string
+		if trimmed := strings.TrimRight(ref, ","); trimmed != ref {
+			ref = trimmed
+		} else {
+			// Descriptors end at the next comma outside parentheses.
+			depth, i := 0, 0
+			for ; i < len(rest); i++ {
+				if c := rest[i]; c == '(' {
+					depth++
+				} else if c == ')' && depth > 0 {
+					depth--
+				} else if c == ',' && depth == 0 {
+					break
+				}
+			}
+			descriptor, rest = strings.TrimSpace(rest[:i]), rest[i:]
+		}
+
+		candidate := fn(ref)
+		if descriptor != "" {
+			candidate += " " + descriptor
+		}
+		candidates = append(candidates, candidate)
+		s = rest
+	}
+	return strings.Join(candidates, ", ")
+}
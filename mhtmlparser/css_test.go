@@ -0,0 +1,104 @@
+package mhtmlparser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want []string // Kind:Ref of each reference, in order
+	}{
+		{name: "comment", css: "/* url(a.png) @import 'x.css'; */ body{background:url(b.png)}", want: []string{"url:b.png"}},
+		{name: "unterminated comment", css: "body{background:url(b.png)} /* url(a.png)", want: []string{"url:b.png"}},
+		{name: "string", css: `a::before{content:"url(x.png)"} b{background:url('c.png')}`, want: []string{"url:c.png"}},
+		{name: "escaped quote in string", css: `a::before{content:"\"url(x.png)"}`, want: nil},
+		{name: "quoted with whitespace", css: `b{background:url(  "a b.png"  )}`, want: []string{"url:a b.png"}},
+		{name: "unquoted with whitespace", css: "b{background:url(\n  a.png\t)}", want: []string{"url:a.png"}},
+		{name: "upper case", css: "b{background:URL(a.png)}", want: []string{"url:a.png"}},
+		{name: "import string", css: `@import "x.css";`, want: []string{"import:x.css"}},
+		{name: "import url", css: "@import url(y.css) screen;", want: []string{"import:y.css"}},
+		{name: "import quoted url", css: `@IMPORT url( 'z.css' );`, want: []string{"import:z.css"}},
+		{
+			name: "font-face in media",
+			css:  `@media print { @font-face { font-family: f; src: url(f.woff2) format("woff2"), url(f.woff); } body { background: url(bg.png) } }`,
+			want: []string{"font-face:f.woff2", "font-face:f.woff", "url:bg.png"},
+		},
+		{name: "after font-face", css: "@font-face{src:url(f.woff)} b{background:url(bg.png)}", want: []string{"font-face:f.woff", "url:bg.png"}},
+		{name: "other functions", css: "b{background:myurl(a.png) x-url(b.png) url(c.png)}", want: []string{"url:c.png"}},
+		{name: "unterminated string", css: "a{content:\"url(x.png)\n} b{background:url(d.png)}", want: []string{"url:d.png"}},
+		{name: "unterminated quoted url", css: `b{background:url("e.png`, want: nil},
+		{name: "unterminated url", css: "b{background:url(e.png", want: nil},
+		{name: "empty url", css: `b{background:url() url( "" )} @import ""; @import url('');`, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, ref := range scanCSS(tt.css) {
+				got = append(got, ref.Kind+":"+ref.Ref)
+				if tt.css[ref.Start:ref.End] != ref.Ref {
+					t.Errorf("%s spans %q", ref.Ref, tt.css[ref.Start:ref.End])
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("scanCSS(%q) = %q, want %q", tt.css, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteCSSRefs(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want string
+	}{
+		{name: "unquoted", css: "b{background:url( a.png )}", want: "b{background:url( new/a.png )}"},
+		{name: "quoted", css: `b{background:url("a.png")}`, want: `b{background:url("new/a.png")}`},
+		{name: "import", css: `@import "x.css"; @import url('y.css');`, want: `@import "new/x.css"; @import url('new/y.css');`},
+		{name: "comment and string", css: `/* url(a.png) */ a::before{content:"url(a.png)"}`, want: `/* url(a.png) */ a::before{content:"url(a.png)"}`},
+		{name: "unchanged", css: "b{background:url(data:image/png;base64,AAAA)}", want: "b{background:url(data:image/png;base64,AAAA)}"},
+		{name: "mixed", css: "b{background:url(a.png), url(data:,x)} i{background:url(b.png)}", want: "b{background:url(new/a.png), url(data:,x)} i{background:url(new/b.png)}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rewriteCSSRefs(tt.css, func(ref string) string {
+				if strings.HasPrefix(ref, "data:") {
+					return ref
+				}
+				return "new/" + ref
+			})
+			if got != tt.want {
+				t.Errorf("rewriteCSSRefs(%q) = %q, want %q", tt.css, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteSrcset(t *testing.T) {
+	tests := []struct {
+		name   string
+		srcset string
+		want   string
+	}{
+		{name: "densities", srcset: "a.png 1x, b.png 2x", want: "[a.png] 1x, [b.png] 2x"},
+		{name: "widths", srcset: "a.png 480w,b.png 800w", want: "[a.png] 480w, [b.png] 800w"},
+		{name: "single", srcset: "a.png", want: "[a.png]"},
+		{name: "missing descriptor", srcset: "a.png, b.png 2x", want: "[a.png], [b.png] 2x"},
+		{name: "missing last descriptor", srcset: "a.png 1x, b.png", want: "[a.png] 1x, [b.png]"},
+		{name: "whitespace", srcset: "  a.png   1x ,\n b.png  ", want: "[a.png] 1x, [b.png]"},
+		{name: "data URI", srcset: "data:image/png;base64,AAAA 1x, b.png 2x", want: "[data:image/png;base64,AAAA] 1x, [b.png] 2x"},
+		{name: "data URI without descriptor", srcset: "data:image/gif;base64,R0lG, b.png 2x", want: "[data:image/gif;base64,R0lG], [b.png] 2x"},
+		{name: "empty", srcset: " , ", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rewriteSrcset(tt.srcset, func(ref string) string { return "[" + ref + "]" })
+			if got != tt.want {
+				t.Errorf("rewriteSrcset(%q) = %q, want %q", tt.srcset, got, tt.want)
+			}
+		})
+	}
+}
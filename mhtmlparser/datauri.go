@@ -0,0 +1,212 @@
+package mhtmlparser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// isDataURI reports whether ref is a data: URI.
+func isDataURI(ref string) bool {
+	return hasPrefixFold(strings.TrimSpace(ref), "data:")
+}
+
+// decodeDataURI returns the media type and payload of a data: URI. Base64 payloads may be
+// wrapped, unpadded or percent-encoded; other payloads are percent-decoded. A URI without a
+// media type is text/plain, as in RFC 2397.
+func decodeDataURI(uri string) (string, []byte, error) {
+	uri = strings.TrimSpace(uri)
+	if !hasPrefixFold(uri, "data:") {
+		return "", nil, errors.New("not a data: URI")
+	}
+	meta, payload, ok := strings.Cut(uri[len("data:"):], ",")
+	if !ok {
+		return "", nil, errors.New("data: URI has no comma")
+	}
+	meta = strings.TrimSpace(meta)
+	isBase64 := false
+	if len(meta) >= len(";base64") && strings.EqualFold(meta[len(meta)-len(";base64"):], ";base64") {
+		isBase64 = true
+		meta = meta[:len(meta)-len(";base64")]
+	}
+	if meta == "" || strings.HasPrefix(meta, ";") {
+		meta = "text/plain" + meta
+	}
+
+	if unescaped, err := url.PathUnescape(payload); err == nil {
+		payload = unescaped
+	}
+	if !isBase64 {
+		return meta, []byte(payload), nil
+	}
+	payload = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t\r\n\f", r) {
+			return -1
+		}
+		return r
+	}, payload)
+	payload = strings.TrimRight(payload, "=")
+	data, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		// Some pages use the URL-safe alphabet.
+		if urlData, urlErr := base64.RawURLEncoding.DecodeString(payload); urlErr == nil {
+			return meta, urlData, nil
+		}
+		return "", nil, fmt.Errorf("invalid base64 payload: %w", err)
+	}
+	return meta, data, nil
+}
+
+// dataURIResource decodes a data: URI into a resource, transcoding text to UTF-8 like parts.
+func dataURIResource(uri string) (Resource, error) {
+	rawContentType, data, err := decodeDataURI(uri)
+	if err != nil {
+		return Resource{}, err
+	}
+	contentType := normalizeContentType(strings.TrimSpace(rawContentType))
+	data, charsetName, _ := decodeCharset(data, rawContentType, contentType)
+	return Resource{
+		Type:    contentType,
+		Data:    data,
+		Size:    len(data),
+		Source:  "data-uri",
+		Charset: charsetName,
+	}, nil
+}
+
+// dataURIExtractor collects the data: URIs of the archive's documents and stylesheets as
+// resources. The same URI is kept once, at the first place it is found.
+type dataURIExtractor struct {
+	p         *MHTMLParser
+	seen      map[string]bool
+	counts    map[string]int // Resources per category, for filenames like data_image_1.png
+	resources []Resource
+}
+
+// add decodes uri, found in element of the document or stylesheet at section, into a
+// resource. Undecodable URIs are recorded as warnings.
+func (e *dataURIExtractor) add(uri, section, element string) {
+	uri = strings.TrimSpace(uri)
+	if !isDataURI(uri) || e.seen[uri] {
+		return
+	}
+	e.seen[uri] = true
+	res, err := dataURIResource(uri)
+	if err != nil {
+		where := "stylesheet"
+		if element != "" {
+			where = element
+		}
+		e.p.warn(Warning{Part: -1, Section: section, Stage: StageInline, Err: fmt.Errorf("invalid data: URI in %s: %w", where, err)})
+		return
+	}
+	if len(res.Data) == 0 {
+		// Nothing to extract, as in the "data:," Prune leaves for removed references.
+		return
+	}
+	category := resourceCategory(res.Type)
+	e.counts[category]++
+	res.Filename = fmt.Sprintf("data_%s_%d%s", category, e.counts[category], extensionFor(res.Type))
+	res.Document = section
+	res.Element = element
+	e.resources = append(e.resources, res)
+}
+
+// css adds the data: URIs referenced by url() values and @import rules in css.
+func (e *dataURIExtractor) css(css, section, element string) {
+	for _, ref := range scanCSS(css) {
+		e.add(ref.Ref, section, element)
+	}
+}
+
+// document adds the data: URIs of an HTML document's URL attributes, srcset candidates,
+// style attributes and <style> blocks.
+func (e *dataURIExtractor) document(document Document) error {
+	if !strings.Contains(strings.ToLower(document.Content), "data:") {
+		return nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(document.Content))
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		selector := elementSelector(s)
+		for _, attr := range urlAttributes {
+			if attr == "data" && goquery.NodeName(s) != "object" {
+				continue
+			}
+			if value, ok := s.Attr(attr); ok {
+				e.add(value, document.Section, selector+"["+attr+"]")
+			}
+		}
+		for _, attr := range []string{"srcset", "imagesrcset"} {
+			if value, ok := s.Attr(attr); ok {
+				rewriteSrcset(value, func(ref string) string {
+					e.add(ref, document.Section, selector+"["+attr+"]")
+					return ref
+				})
+			}
+		}
+		if value, ok := s.Attr("style"); ok {
+			e.css(value, document.Section, selector+"[style]")
+		}
+		if goquery.NodeName(s) == "style" {
+			e.css(s.Text(), document.Section, selector)
+		}
+	})
+	return nil
+}
+
+// extractDataURIs returns the data: URIs of every HTML document and embedded stylesheet as
+// resources with Source "data-uri". Document is the section of the HTML document or
+// stylesheet holding the URI, and Element the element and attribute it was found in.
+func (p *MHTMLParser) extractDataURIs() []Resource {
+	e := &dataURIExtractor{p: p, seen: make(map[string]bool), counts: make(map[string]int)}
+	for _, document := range p.Documents {
+		if err := e.document(document); err != nil {
+			p.warn(Warning{Part: -1, Section: document.Section, Stage: StageInline, URL: document.URL, Err: err})
+		}
+	}
+	for _, res := range p.Resources {
+		if res.Source != "embedded" || res.Type != "text/css" {
+			continue
+		}
+		data, err := res.readAll()
+		if err != nil {
+			p.warn(Warning{Part: -1, Section: res.Section, Stage: StageRead, URL: res.URL, Err: err})
+			continue
+		}
+		e.css(string(data), res.Section, "")
+	}
+	return e.resources
+}
+
+// matchDataURI returns the data-uri resource among candidates holding the payload of ref,
+// or nil when ref is not a data: URI or none matches.
+func matchDataURI(ref string, candidates map[*Resource]bool) *Resource {
+	if !isDataURI(ref) {
+		return nil
+	}
+	var decoded *Resource
+	for res := range candidates {
+		if res.Source != "data-uri" {
+			continue
+		}
+		if decoded == nil {
+			d, err := dataURIResource(ref)
+			if err != nil {
+				return nil
+			}
+			decoded = &d
+		}
+		if res.Type == decoded.Type && bytes.Equal(res.Data, decoded.Data) {
+			return res
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package mhtmlparser
+
+// Document is an HTML document stored in the archive, either the main page or one of its
+// frames and iframes.
+type Document struct {
+	URL     string // Content-Location of the document
+	Section string // Section of the part holding the document
+	Content string // Document HTML, transcoded to UTF-8
+	Main    bool   // Whether this is the saved page rather than a frame
+}
+
+// MainDocument returns the saved page, or nil if the archive has no HTML document.
+func (p *MHTMLParser) MainDocument() *Document {
+	if len(p.Documents) == 0 {
+		return nil
+	}
+	return &p.Documents[0]
+}
+
+// Frames returns the frame and iframe documents embedded in the main page.
+func (p *MHTMLParser) Frames() []Document {
+	if len(p.Documents) < 2 {
+		return nil
+	}
+	return p.Documents[1:]
+}
+
+// pickMainDocument moves the main document to the front of Documents. The main document is the
+// one whose URL matches the archive's Snapshot-Content-Location, or else the first HTML part.
+func (p *MHTMLParser) pickMainDocument(snapshotURL string) {
+	if len(p.Documents) == 0 {
+		return
+	}
+	main := 0
+	if snapshotURL != "" {
+		target := normalizeURL(snapshotURL)
+		for i, doc := range p.Documents {
+			if doc.URL != "" && normalizeURL(doc.URL) == target {
+				main = i
+				break
+			}
+		}
+	}
+	if main != 0 {
+		doc := p.Documents[main]
+		copy(p.Documents[1:main+1], p.Documents[:main])
+		p.Documents[0] = doc
+	}
+	p.Documents[0].Main = true
+	p.HTMLContent = p.Documents[0].Content
+	p.BaseURL = p.Documents[0].URL
+}
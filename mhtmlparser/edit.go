@@ -0,0 +1,180 @@
+package mhtmlparser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReplaceData replaces the data of the resource at index, for example to patch a script or
+// redact an image before Save. Text is taken to be in the charset it declares itself, or
+// UTF-8 when it declares none, and is transcoded to UTF-8 like parsed parts; undeclared HTML
+// that is not valid UTF-8 is read as windows-1252, as browsers do. Replacing an HTML
+// document also updates its Document.
+func (p *MHTMLParser) ReplaceData(index int, data []byte) error {
+	if index < 0 || index >= len(p.Resources) {
+		return fmt.Errorf("invalid resource index: %d", index)
+	}
+	data, name, err := decodeCharset(data, p.Resources[index].Type, p.Resources[index].Type)
+	if err != nil {
+		return err
+	}
+	p.setData(index, data, name)
+	return nil
+}
+
+// setData stores UTF-8 data for the resource at index and keeps its Document in sync.
+func (p *MHTMLParser) setData(index int, data []byte, charsetName string) {
+	res := &p.Resources[index]
+	res.Data, res.Size, res.Charset, res.spillPath = data, len(data), charsetName, ""
+
+	if res.Source == "embedded" && res.Type == "text/html" {
+		for i := range p.Documents {
+			if p.Documents[i].Section == res.Section {
+				p.Documents[i].Content = string(data)
+				if p.Documents[i].Main {
+					p.HTMLContent = string(data)
+				}
+			}
+		}
+	}
+}
+
+// AddResource appends res to the archive as a new embedded part and returns its index.
+// A filename is derived when res has none, and HTML parts become frame documents. The part
+// is added to the end of Root.
+func (p *MHTMLParser) AddResource(res Resource) int {
+	if res.Type == "" {
+		res.Type = "application/octet-stream"
+	}
+	res.Source = "embedded"
+	res.Size = len(res.Data)
+	res.spillPath = ""
+	res.Section = p.nextSection()
+	if res.Filename == "" {
+		prefix := "resource"
+		if res.Type == "text/html" {
+			prefix = "page"
+		}
+		res.Filename = deriveFilename(res, "", prefix)
+	}
+	p.Resources = append(p.Resources, res)
+	makeFilenamesUnique(p.Resources)
+	p.attachNode(res)
+
+	if res.Type == "text/html" {
+		p.Documents = append(p.Documents, Document{URL: res.URL, Section: res.Section, Content: string(res.Data)})
+	}
+	return len(p.Resources) - 1
+}
+
+// RemoveResource deletes the resource at index, so it is neither extracted nor saved.
+// Resources after it move down one index, and pointers returned by Lookup become stale.
+// Its node is taken out of Root, along with containers left empty. The main document
+// cannot be removed.
+func (p *MHTMLParser) RemoveResource(index int) error {
+	if index < 0 || index >= len(p.Resources) {
+		return fmt.Errorf("invalid resource index: %d", index)
+	}
+	res := p.Resources[index]
+	if res.Source == "embedded" && res.Type == "text/html" {
+		for i, doc := range p.Documents {
+			if doc.Section != res.Section {
+				continue
+			}
+			if doc.Main {
+				return errors.New("the main document cannot be removed")
+			}
+			p.Documents = append(p.Documents[:i], p.Documents[i+1:]...)
+			break
+		}
+	}
+	p.Resources = append(p.Resources[:index], p.Resources[index+1:]...)
+	if res.Source == "embedded" {
+		p.detachNode(res.Section)
+	}
+	return nil
+}
+
+// Save writes the archive with any edits to w. The archive headers and each part's
+// headers are kept. The main document is written first, as RFC 2557 readers expect, and
+// the other parts follow in their original order, then added parts. Nested multiparts are
+// flattened into one multipart/related body, and inline, data: URI or downloaded
+// resources, which were never parts of the archive, are left out.
+func (p *MHTMLParser) Save(w io.Writer) error {
+	mw := NewWriter(w)
+	if err := mw.WriteHeader(p.Metadata); err != nil {
+		return err
+	}
+	main := -1
+	if doc := p.MainDocument(); doc != nil {
+		for i, res := range p.Resources {
+			if res.Source == "embedded" && res.Section == doc.Section {
+				main = i
+				if err := mw.WriteResource(res); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	for i, res := range p.Resources {
+		if res.Source != "embedded" || i == main {
+			continue
+		}
+		if err := mw.WriteResource(res); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// SaveFile saves the archive to path. It writes a temporary file next to path first, so
+// path is left untouched if saving fails and may be the archive that was parsed.
+func (p *MHTMLParser) SaveFile(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".mhtml-save-*")
+	if err != nil {
+		return err
+	}
+	// CreateTemp makes the file private; saved archives get the usual permissions.
+	err = file.Chmod(0644)
+	if err == nil {
+		err = p.Save(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to save %s: %w", path, err)
+	}
+	return nil
+}
+
+// nextSection returns the section number of a part appended to the top-level multipart.
+func (p *MHTMLParser) nextSection() string {
+	last := 0
+	check := func(section string) {
+		top, _, _ := strings.Cut(section, ".")
+		if n, err := strconv.Atoi(top); err == nil && n > last {
+			last = n
+		}
+	}
+	for _, res := range p.Resources {
+		check(res.Section)
+	}
+	// Discarded parts keep their nodes, so their sections are taken too.
+	if p.Root != nil {
+		for _, child := range p.Root.Children {
+			check(child.Section)
+		}
+	}
+	return strconv.Itoa(last + 1)
+}
@@ -0,0 +1,104 @@
+package mhtmlparser
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSaveWritesMainDocumentFirst(t *testing.T) {
+	archive := "Content-Type: multipart/related; boundary=outer; type=\"text/html\"\r\n\r\n" +
+		"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+		"--inner\r\nContent-Type: text/plain\r\n\r\nplain version\r\n" +
+		"--inner\r\nContent-Type: text/html\r\nContent-Location: http://example.com/\r\n\r\n<p>html version</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\nContent-Type: image/png\r\nContent-Location: http://example.com/a.png\r\n\r\npng\r\n" +
+		"--outer--\r\n"
+	p := New("", false)
+	if err := p.ParseReader(context.Background(), strings.NewReader(archive), ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	saved := New("", false)
+	if err := saved.ParseReader(context.Background(), &buf, ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader of saved archive: %v", err)
+	}
+	var types []string
+	for _, res := range saved.Resources {
+		if res.Source == "embedded" {
+			types = append(types, res.Type)
+		}
+	}
+	if want := "text/html text/plain image/png"; strings.Join(types, " ") != want {
+		t.Errorf("saved parts are %v, want %s", types, want)
+	}
+}
+
+func TestReplaceDataUndeclaredUTF8HTML(t *testing.T) {
+	p := New("", false)
+	index := p.AddResource(Resource{Type: "text/html", Data: []byte("<p>old</p>")})
+	data := "<p>" + strings.Repeat("x", 1100) + "café</p>"
+	if err := p.ReplaceData(index, []byte(data)); err != nil {
+		t.Fatalf("ReplaceData: %v", err)
+	}
+	if got := p.Resources[index]; string(got.Data) != data || got.Charset != "utf-8" {
+		t.Errorf("replaced data is %q in %s, want it unchanged in utf-8", got.Data[len(got.Data)-10:], got.Charset)
+	}
+}
+
+func TestAddRemoveResourceUpdatesTree(t *testing.T) {
+	archive := "Content-Type: multipart/related; boundary=outer; type=\"text/html\"\r\n\r\n" +
+		"--outer\r\nContent-Type: text/html\r\nContent-Location: http://example.com/\r\n\r\n<p>page</p>\r\n" +
+		"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+		"--inner\r\nContent-Type: image/png\r\nContent-Location: http://example.com/a.png\r\n\r\npng\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\nContent-Type: text/css\r\nContent-Location: http://example.com/a.css\r\n\r\nbody{}\r\n" +
+		"--outer--\r\n"
+	p := New("", false)
+	if err := p.ParseReader(context.Background(), strings.NewReader(archive), ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+	sections := func() string {
+		var out []string
+		p.Root.Walk(func(n *PartNode) bool {
+			if n != p.Root {
+				out = append(out, n.Section)
+			}
+			return true
+		})
+		return strings.Join(out, " ")
+	}
+	if got := sections(); got != "1 2 2.1 3" {
+		t.Fatalf("parsed tree %q", got)
+	}
+
+	image := slices.IndexFunc(p.Resources, func(res Resource) bool { return res.Section == "2.1" })
+	if err := p.RemoveResource(image); err != nil {
+		t.Fatalf("RemoveResource: %v", err)
+	}
+	if p.Node("2.1") != nil || p.Node("2") != nil {
+		t.Errorf("removed part or its empty container still in the tree: %q", sections())
+	}
+
+	index := p.AddResource(Resource{Type: "image/gif", URL: "http://example.com/b.gif", Data: []byte("gif")})
+	added := &p.Resources[index]
+	n := p.Node(added.Section)
+	if n == nil || n.Type != "image/gif" || p.ContainerOf(added) != p.Root || p.ResourceFor(n) != added {
+		t.Errorf("added part %s missing from the tree: %q", added.Section, sections())
+	}
+	if got := sections(); got != "1 3 4" {
+		t.Errorf("edited tree %q, want 1 3 4", got)
+	}
+
+	fresh := New("", false)
+	index = fresh.AddResource(Resource{Type: "text/html", Data: []byte("<p>new</p>")})
+	if n := fresh.Node(fresh.Resources[index].Section); n == nil || n.Parent != fresh.Root {
+		t.Error("part added to an empty parser has no node")
+	}
+}
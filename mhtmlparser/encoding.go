@@ -0,0 +1,181 @@
+package mhtmlparser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// newTransferDecoder wraps a raw part body with a reader that undoes its Content-Transfer-Encoding.
+// Decoding failures are reported as *CorruptBodyError; errors from r itself are passed through.
+func newTransferDecoder(r io.Reader, encoding string) io.Reader {
+	src := &sourceReader{r: r}
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+
+	var dec io.Reader
+	switch encoding {
+	case "", "7bit", "8bit", "binary":
+		return r
+	case "base64":
+		dec = base64.NewDecoder(base64.RawStdEncoding, &base64Filter{r: src})
+	case "quoted-printable":
+		dec = quotedprintable.NewReader(src)
+	case "x-uuencode", "uuencode", "x-uue":
+		dec = &uuDecoder{r: bufio.NewReader(src)}
+	default:
+		return &unsupportedEncodingReader{r: r, encoding: encoding}
+	}
+	return &transferDecoder{src: src, dec: dec, encoding: encoding}
+}
+
+// transferDecoder tags decoder errors as corrupt input.
+type transferDecoder struct {
+	src      *sourceReader
+	dec      io.Reader
+	encoding string
+}
+
+func (d *transferDecoder) Read(p []byte) (int, error) {
+	n, err := d.dec.Read(p)
+	if err != nil && err != io.EOF {
+		// A failing raw body, such as a truncated archive, takes precedence over the decoding error it causes.
+		if d.src.err != nil {
+			return n, d.src.err
+		}
+		err = &CorruptBodyError{Encoding: d.encoding, Err: err}
+	}
+	return n, err
+}
+
+// sourceReader records the last non-EOF error returned by the raw body.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// base64Filter drops whitespace and padding so that wrapped or unpadded base64 decodes cleanly.
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		j := 0
+		for _, c := range p[:n] {
+			switch c {
+			case ' ', '\t', '\r', '\n', '\f', '\v', '=':
+				continue
+			}
+			p[j] = c
+			j++
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
+
+// unsupportedEncodingReader passes the body through unchanged and reports the unknown encoding at EOF.
+type unsupportedEncodingReader struct {
+	r        io.Reader
+	encoding string
+}
+
+func (u *unsupportedEncodingReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if err == io.EOF {
+		err = &CorruptBodyError{Encoding: u.encoding, Err: fmt.Errorf("%w, body kept as-is", ErrUnsupportedEncoding)}
+	}
+	return n, err
+}
+
+// uuDecoder decodes a uuencoded body, skipping anything outside the begin/end block.
+type uuDecoder struct {
+	r       *bufio.Reader
+	buf     []byte
+	started bool
+	done    bool
+}
+
+func (u *uuDecoder) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		if u.done {
+			return 0, io.EOF
+		}
+		if err := u.decodeLine(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+func (u *uuDecoder) decodeLine() error {
+	line, err := u.r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	atEOF := err == io.EOF
+	line = bytes.TrimRight(line, "\r\n")
+
+	switch {
+	case !u.started:
+		if bytes.HasPrefix(line, []byte("begin ")) {
+			u.started = true
+		} else if atEOF {
+			return errors.New("missing begin line")
+		}
+		return nil
+	case bytes.Equal(bytes.TrimSpace(line), []byte("end")):
+		u.done = true
+		return nil
+	case len(line) == 0:
+		if atEOF {
+			return errors.New("missing end line")
+		}
+		return nil
+	}
+
+	size := int((line[0] - ' ') & 0x3f)
+	if size == 0 {
+		// The zero-length line just before "end".
+		if atEOF {
+			u.done = true
+		}
+		return nil
+	}
+	body := line[1:]
+	out := make([]byte, 0, size+2)
+	for i := 0; i < len(body); i += 4 {
+		var c [4]byte
+		for j := range c {
+			if i+j < len(body) {
+				c[j] = (body[i+j] - ' ') & 0x3f
+			}
+		}
+		out = append(out, c[0]<<2|c[1]>>4, c[1]<<4|c[2]>>2, c[2]<<6|c[3])
+	}
+	if len(out) < size {
+		return fmt.Errorf("short uuencoded line: want %d bytes, got %d", size, len(out))
+	}
+	u.buf = out[:size]
+	if atEOF {
+		u.done = true
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTransferDecoder(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		want     string
+		wantErr  error // nil when decoding must succeed
+	}{
+		{name: "identity", encoding: "8bit", body: "héllo", want: "héllo"},
+		{name: "base64", encoding: "base64", body: "aGVsbG8gd29ybGQ=", want: "hello world"},
+		{name: "base64 wrapped", encoding: "BASE64", body: "aGVs\r\nbG8g\r\nd29y\r\nbGQ=\r\n", want: "hello world"},
+		{name: "base64 unpadded", encoding: "base64", body: "aGVsbG8gd29ybGQ", want: "hello world"},
+		{name: "base64 corrupt", encoding: "base64", body: "aGVs*G8=", want: "hel", wantErr: ErrCorruptBody},
+		{name: "quoted-printable", encoding: "quoted-printable", body: "caf=C3=A9 =\r\nau lait", want: "café au lait"},
+		{name: "uuencode", encoding: "x-uuencode", body: "begin 644 a.txt\n#86)C\n`\nend\n", want: "abc"},
+		{name: "uuencode without end", encoding: "x-uuencode", body: "begin 644 a.txt\n#86)C\n`\n\n", want: "abc", wantErr: ErrCorruptBody},
+		{name: "uuencode without begin", encoding: "uuencode", body: "#86)C\n", wantErr: ErrCorruptBody},
+		{name: "unknown encoding", encoding: "x-rot13", body: "uryyb", want: "uryyb", wantErr: ErrUnsupportedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(newTransferDecoder(strings.NewReader(tt.body), tt.encoding))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				var corrupt *CorruptBodyError
+				if !errors.As(err, &corrupt) || !errors.Is(err, ErrCorruptBody) {
+					t.Fatalf("error = %#v, want a *CorruptBodyError", err)
+				}
+			}
+			if tt.want != "" && string(got) != tt.want {
+				t.Errorf("decoded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransferDecoderSourceError(t *testing.T) {
+	// A failing archive must not be reported as a corrupt body.
+	r := io.MultiReader(strings.NewReader("aGVs"), errReader{io.ErrUnexpectedEOF})
+	_, err := io.ReadAll(newTransferDecoder(r, "base64"))
+	if !errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrCorruptBody) {
+		t.Fatalf("error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestParseCorruptAndTruncated(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		want    error
+		stage   string
+	}{
+		{
+			name: "corrupt base64",
+			archive: "Content-Type: multipart/related; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: image/png\r\nContent-Transfer-Encoding: base64\r\n\r\niVBO*w0K\r\n" +
+				"--b--\r\n",
+			want:  ErrCorruptBody,
+			stage: StageDecode,
+		},
+		{
+			name: "missing closing boundary",
+			archive: "Content-Type: multipart/related; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>cut",
+			want:  ErrTruncated,
+			stage: StageRead,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New("", false)
+			if err := p.ParseReader(context.Background(), strings.NewReader(tt.archive), ParseOptions{}); err != nil {
+				t.Fatalf("ParseReader: %v", err)
+			}
+			for _, w := range p.Warnings {
+				if errors.Is(w, tt.want) && w.Stage == tt.stage {
+					return
+				}
+			}
+			t.Fatalf("warnings = %v, want one matching %v at stage %s", p.Warnings, tt.want, tt.stage)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package mhtmlparser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by Parse and ParseReader, or wrapped in a Warning. Match them with errors.Is.
+var (
+	ErrNotMultipart        = errors.New("mhtmlparser: archive is not a multipart document")
+	ErrNoBoundary          = errors.New("mhtmlparser: multipart boundary missing")
+	ErrTruncated           = errors.New("mhtmlparser: archive is truncated")
+	ErrCorruptBody         = errors.New("mhtmlparser: corrupt part body")
+	ErrUnsupportedEncoding = errors.New("mhtmlparser: unsupported Content-Transfer-Encoding")
+	ErrUnsupportedCharset  = errors.New("mhtmlparser: unsupported charset")
+	ErrResponseTooLarge    = errors.New("mhtmlparser: response exceeds MaxResponseSize")
+	ErrNotCached           = errors.New("mhtmlparser: resource not in the cache")
+	ErrBlocked             = errors.New("mhtmlparser: download blocked by FetchPolicy")
+	ErrFetchLimit          = errors.New("mhtmlparser: FetchPolicy download limit reached")
+)
+
+// ErrStop can be returned from ParseOptions.OnPart to end parsing early without an error.
+// Parts read so far are kept, but inline scripts, data: URIs and external resources are not processed.
+var ErrStop = errors.New("mhtmlparser: stop parsing")
+
+// Processing stages reported in Warning.Stage.
+const (
+	StageRead    = "read"    // Reading the MIME structure or a part body
+	StageDecode  = "decode"  // Undoing a part's Content-Transfer-Encoding
+	StageCharset = "charset" // Transcoding a text part to UTF-8
+	StageInline  = "inline"  // Extracting inline resources from an HTML document
+	StageFetch   = "fetch"   // Downloading an external resource
+	StageCleanup = "cleanup" // Removing temporary files
+)
+
+// Warning is a recoverable problem met while parsing an archive or fetching its resources.
+// Parsing continues after a warning; the affected part is kept as far as it could be read.
+type Warning struct {
+	Part    int    // Index of the MIME part as passed to ParseOptions.OnPart, -1 if not tied to a part
+	Section string // Section of the part or document involved, if any
+	Stage   string // One of the Stage constants
+	URL     string // URL of the resource involved, if any
+	Err     error
+}
+
+func (w Warning) Error() string {
+	var b strings.Builder
+	b.WriteString(w.Stage)
+	if w.Section != "" {
+		fmt.Fprintf(&b, " part %s", w.Section)
+	} else if w.Part >= 0 {
+		fmt.Fprintf(&b, " part #%d", w.Part)
+	}
+	if w.URL != "" {
+		fmt.Fprintf(&b, " (%s)", w.URL)
+	}
+	fmt.Fprintf(&b, ": %v", w.Err)
+	return b.String()
+}
+
+func (w Warning) Unwrap() error {
+	return w.Err
+}
+
+// CorruptBodyError reports a part body that could not be fully decoded.
+// The bytes decoded before the failure are still kept on the Resource.
+type CorruptBodyError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *CorruptBodyError) Error() string {
+	return fmt.Sprintf("corrupt %s body: %v", e.Encoding, e.Err)
+}
+
+func (e *CorruptBodyError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes every CorruptBodyError match ErrCorruptBody.
+func (e *CorruptBodyError) Is(target error) bool {
+	return target == ErrCorruptBody
+}
+
+// StatusError reports an HTTP response with an unexpected status code.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d for %s", e.StatusCode, e.URL)
+}
+
+// warn records a warning and passes it to the OnWarning callback of the running parse, if any.
+func (p *MHTMLParser) warn(w Warning) {
+	p.Warnings = append(p.Warnings, w)
+	if p.onWarning != nil {
+		p.onWarning(w)
+	}
+}
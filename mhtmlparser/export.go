@@ -0,0 +1,224 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// urlAttributes lists the HTML attributes holding a single URL that ExportSite rewrites.
+var urlAttributes = []string{"src", "href", "poster", "background", "data"}
+
+// ExportSite writes every archived resource to outputDir, like ExtractResources, and rewrites
+// the references in HTML and CSS parts to relative paths of the written files, so the result
+// can be browsed offline. It returns the path of the main document.
+func (p *MHTMLParser) ExportSite(outputDir string) (string, error) {
+	return p.ExportSiteContext(context.Background(), outputDir)
+}
+
+// ExportSiteContext is like ExportSite but stops when ctx is cancelled.
+func (p *MHTMLParser) ExportSiteContext(ctx context.Context, outputDir string) (string, error) {
+	mainDoc := p.MainDocument()
+	if mainDoc == nil {
+		return "", errors.New("no HTML document to export")
+	}
+
+	// Inline scripts and data: URIs are already part of their page.
+	var indices []int
+	mainIndex := -1
+	for i, res := range p.Resources {
+		if res.Source == "inline" || res.Source == "data-uri" {
+			continue
+		}
+		if res.Source == "embedded" && res.Section == mainDoc.Section {
+			mainIndex = i
+		}
+		indices = append(indices, i)
+	}
+	if mainIndex < 0 {
+		return "", errors.New("main document has no matching resource")
+	}
+
+	index := make(map[*Resource]int, len(p.Resources))
+	for i := range p.Resources {
+		index[&p.Resources[i]] = i
+	}
+
+	paths, err := p.extract(ctx, outputDir, indices, func(i int, planned map[int]string) ([]byte, error) {
+		res := p.Resources[i]
+		// link returns the path of the file ref points to, relative to the file being written.
+		link := func(ref, base string) string {
+			ref = strings.TrimSpace(ref)
+			if ref == "" || strings.HasPrefix(ref, "#") {
+				return ref
+			}
+			if target := p.LookupFrom(ref, base); target != nil {
+				if path, ok := planned[index[target]]; ok {
+					return relativeLink(planned[i], path, ref)
+				}
+			}
+			return absoluteLink(base, ref)
+		}
+
+		switch {
+		case res.Type == "text/html" && res.Source == "embedded":
+			return rewriteHTML(res, p.documentBase(res), link)
+		case res.Type == "text/css":
+			data, err := res.readAll()
+			if err != nil {
+				return nil, err
+			}
+			return []byte(rewriteCSSRefs(string(data), func(ref string) string {
+				return link(ref, res.URL)
+			})), nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	for j, i := range indices {
+		if i == mainIndex {
+			return paths[j], nil
+		}
+	}
+	return "", nil
+}
+
+// documentBase returns the URL relative references in an HTML resource resolve against.
+func (p *MHTMLParser) documentBase(res Resource) string {
+	if res.URL != "" {
+		return res.URL
+	}
+	return p.BaseURL
+}
+
+// rewriteHTML passes every URL reference in an HTML document through link and returns the
+// rewritten document.
+func rewriteHTML(res Resource, base string, link func(ref, base string) string) ([]byte, error) {
+	doc, base, err := parseHTMLDocument(string(res.Data), base)
+	if err != nil {
+		return nil, err
+	}
+	rewriteReferences(doc, func(ref string) string { return link(ref, base) })
+	dropIntegrity(doc)
+	return renderHTML(doc)
+}
+
+// parseHTMLDocument parses an HTML document and returns it with the URL its relative
+// references resolve against. The <base> element is dropped since rewritten references
+// no longer depend on it.
+func parseHTMLDocument(content, base string) (*goquery.Document, string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return nil, "", err
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		base = resolveURL(base, href)
+	}
+	doc.Find("base").Remove()
+	return doc, base, nil
+}
+
+// rewriteReferences passes every URL in the attributes, style attributes and <style> blocks
+// of doc through rewrite.
+func rewriteReferences(doc *goquery.Document, rewrite func(ref string) string) {
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		set := func(attr, value, updated string) {
+			if updated != value {
+				s.SetAttr(attr, updated)
+			}
+		}
+		for _, attr := range urlAttributes {
+			if attr == "data" && goquery.NodeName(s) != "object" {
+				continue
+			}
+			if value, ok := s.Attr(attr); ok {
+				set(attr, value, rewrite(value))
+			}
+		}
+		for _, attr := range []string{"srcset", "imagesrcset"} {
+			if value, ok := s.Attr(attr); ok {
+				set(attr, value, rewriteSrcset(value, rewrite))
+			}
+		}
+		if value, ok := s.Attr("style"); ok {
+			set("style", value, rewriteCSSRefs(value, rewrite))
+		}
+	})
+	doc.Find("style").Each(func(_ int, s *goquery.Selection) {
+		if css := s.Text(); css != "" {
+			if updated := rewriteCSSRefs(css, rewrite); updated != css {
+				setRawText(s, updated)
+			}
+		}
+	})
+}
+
+// dropIntegrity removes integrity attributes, since archived files are often transcoded or
+// rewritten and no longer match their hash.
+func dropIntegrity(doc *goquery.Document) {
+	doc.Find("[integrity]").RemoveAttr("integrity")
+}
+
+// setRawText replaces the content of each element in s with text. Unlike SetText it does not
+// escape the text, which is what raw text elements like <style> and <script> need.
+func setRawText(s *goquery.Selection, text string) {
+	for _, n := range s.Nodes {
+		for n.FirstChild != nil {
+			n.RemoveChild(n.FirstChild)
+		}
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	}
+}
+
+// renderHTML serializes doc.
+func renderHTML(doc *goquery.Document) ([]byte, error) {
+	out, err := doc.Html()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render HTML: %w", err)
+	}
+	return []byte(out), nil
+}
+
+// relativeLink returns a URL path from the file at from to the file at to, keeping the
+// fragment of the original reference.
+func relativeLink(from, to, ref string) string {
+	rel, err := filepath.Rel(filepath.Dir(from), to)
+	if err != nil {
+		return ref
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	link := strings.Join(segments, "/")
+	if _, fragment, ok := strings.Cut(ref, "#"); ok {
+		link += "#" + fragment
+	}
+	return link
+}
+
+// absoluteLink resolves a reference that is not in the archive against base, so it still
+// points at the original web location once the page is opened from disk. Fragment-only
+// references point into the document itself and are kept.
+func absoluteLink(base, ref string) string {
+	if base == "" || strings.HasPrefix(ref, "#") {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.IsAbs() {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err != nil || (b.Scheme != "http" && b.Scheme != "https") {
+		return ref
+	}
+	return b.ResolveReference(u).String()
+}
@@ -0,0 +1,460 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// externalRef is a subresource that a document or stylesheet loads from the network.
+type externalRef struct {
+	URL      string // Absolute http or https URL, without fragment
+	Document string // Section of the HTML document or stylesheet it was found in
+	Hint     string // Content type the referencing element expects, e.g. text/css for a stylesheet link
+}
+
+// genericTypes are Content-Type values that say nothing about a download, so its file
+// extension or referencing element decides its type instead.
+var genericTypes = map[string]bool{
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/unknown":      true,
+	"text/plain":               true,
+}
+
+// fetchRetryDelay is the wait before the first retry of a download; it doubles each retry.
+const fetchRetryDelay = 500 * time.Millisecond
+
+// fetchExternal downloads the subresources of every document and stylesheet that are not in
+// the archive: scripts, stylesheets, images, media, fonts, icons and preloads, srcset
+// candidates, and CSS url() and @import references, including those of downloaded
+// stylesheets. Frames are not fetched. Failed downloads are recorded as warnings.
+//
+// Downloads run in rounds on a pool of FetchWorkers, the references of each round's
+// stylesheets making up the next round. Resources are returned in the order they were
+// found, however the downloads finish. URLs are checked against FetchPolicy before they
+// are scheduled, and downloads stop once its limits are reached.
+func (p *MHTMLParser) fetchExternal(ctx context.Context) []Resource {
+	policy := p.FetchPolicy
+	ctx = withFetchPolicy(ctx, &policy)
+	budget := newFetchBudget(policy.MaxTotalBytes)
+
+	seen := make(map[string]bool)
+	scheduled := 0
+	var queue []externalRef
+	enqueue := func(refs []externalRef) {
+		for _, ref := range refs {
+			if seen[ref.URL] || p.LookupFrom(ref.URL, "") != nil {
+				continue
+			}
+			seen[ref.URL] = true
+			if err := policy.checkURL(ref.URL); err != nil {
+				p.warn(Warning{Part: -1, Section: ref.Document, Stage: StageFetch, URL: ref.URL, Err: err})
+				continue
+			}
+			if policy.MaxDownloads > 0 && scheduled >= policy.MaxDownloads {
+				if scheduled == policy.MaxDownloads {
+					err := fmt.Errorf("%w: MaxDownloads of %d reached, skipping further resources", ErrFetchLimit, policy.MaxDownloads)
+					p.warn(Warning{Part: -1, Section: ref.Document, Stage: StageFetch, URL: ref.URL, Err: err})
+					scheduled++
+				}
+				continue
+			}
+			scheduled++
+			queue = append(queue, ref)
+			p.progress.TotalDownloads++
+		}
+	}
+
+	for _, document := range p.Documents {
+		refs, err := p.documentRefs(document)
+		if err != nil {
+			p.warn(Warning{Part: -1, Section: document.Section, Stage: StageFetch, URL: document.URL, Err: err})
+			continue
+		}
+		enqueue(refs)
+	}
+	for _, ref := range p.CSSReferences() {
+		if ref.Origin == OriginStylesheet && ref.Missing() && isHTTPURL(ref.URL) {
+			enqueue([]externalRef{{URL: ref.URL, Document: ref.Section, Hint: cssHint(ref.Kind)}})
+		}
+	}
+	p.progress.Stage = StageFetch
+	p.reportProgress()
+	if p.Fetcher == nil {
+		p.Fetcher = defaultFetcher()
+	}
+
+	var results []Resource
+	limiter := newHostLimiter(p.FetchPerHost)
+	for len(queue) > 0 && ctx.Err() == nil {
+		round := queue
+		queue = nil
+		for _, res := range p.fetchRound(ctx, round, limiter, budget) {
+			results = append(results, res)
+			if res.Type == "text/css" {
+				enqueue(stylesheetRefs(string(res.Data), res.URL, res.Document))
+			}
+		}
+	}
+	return results
+}
+
+// download is the outcome of fetching one externalRef.
+type download struct {
+	index       int
+	data        []byte
+	contentType string // Content-Type response header
+	err         error
+}
+
+// fetchRound downloads refs on the worker pool and returns the resources that succeeded in
+// the order of refs. Warnings and progress are reported from the calling goroutine.
+func (p *MHTMLParser) fetchRound(ctx context.Context, refs []externalRef, limiter *hostLimiter, budget *fetchBudget) []Resource {
+	jobs := make(chan int)
+	done := make(chan download)
+	var wg sync.WaitGroup
+	for range min(max(p.FetchWorkers, 1), len(refs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				d := download{index: i}
+				release, err := limiter.acquire(ctx, refs[i].URL)
+				if err == nil {
+					d.data, d.contentType, d.err = p.fetchWithRetry(ctx, refs[i].URL, budget)
+					release()
+				} else {
+					d.err = err
+				}
+				done <- d
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range refs {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	downloads := make([]*download, len(refs))
+	for d := range done {
+		p.progress.Downloads++
+		p.reportProgress()
+		downloads[d.index] = &d
+	}
+
+	var results []Resource
+	for i, d := range downloads {
+		if d == nil {
+			continue
+		}
+		ref := refs[i]
+		if d.err != nil {
+			if ctx.Err() == nil {
+				p.warn(Warning{Part: -1, Section: ref.Document, Stage: StageFetch, URL: ref.URL, Err: d.err})
+			}
+			continue
+		}
+		rawContentType := externalType(d.contentType, ref, d.data)
+		contentType := normalizeContentType(rawContentType)
+		data, charsetName, err := decodeCharset(d.data, rawContentType, contentType)
+		if err != nil {
+			p.warn(Warning{Part: -1, Section: ref.Document, Stage: StageCharset, URL: ref.URL, Err: err})
+		}
+		res := Resource{
+			Type:     contentType,
+			Data:     data,
+			Size:     len(data),
+			Source:   "external",
+			Charset:  charsetName,
+			URL:      ref.URL,
+			Document: ref.Document,
+		}
+		res.Filename = deriveFilename(res, "", "resource")
+		results = append(results, res)
+	}
+	return results
+}
+
+// fetchWithRetry downloads rawURL, retrying up to FetchRetries times after a 5xx response or
+// a timeout. It returns the body and the Content-Type header.
+func (p *MHTMLParser) fetchWithRetry(ctx context.Context, rawURL string, budget *fetchBudget) ([]byte, string, error) {
+	delay := fetchRetryDelay
+	for attempt := 0; ; attempt++ {
+		data, contentType, err := p.fetch(ctx, rawURL, budget)
+		if err == nil || attempt >= p.FetchRetries || !retryable(ctx, err) {
+			return data, contentType, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, "", ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// fetch downloads rawURL once, reading at most MaxResponseSize bytes of the body and no
+// more than budget has left.
+func (p *MHTMLParser) fetch(ctx context.Context, rawURL string, budget *fetchBudget) ([]byte, string, error) {
+	if budget.exhausted() {
+		return nil, "", budget.err()
+	}
+	resp, err := p.get(ctx, rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &StatusError{URL: rawURL, StatusCode: resp.StatusCode}
+	}
+	if p.MaxResponseSize > 0 && resp.ContentLength > p.MaxResponseSize {
+		return nil, "", fmt.Errorf("%w: %d bytes", ErrResponseTooLarge, resp.ContentLength)
+	}
+
+	body := io.Reader(resp.Body)
+	if p.MaxResponseSize > 0 {
+		body = io.LimitReader(resp.Body, p.MaxResponseSize+1)
+	}
+	data, err := io.ReadAll(budget.reader(body))
+	if errors.Is(err, ErrFetchLimit) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	if p.MaxResponseSize > 0 && int64(len(data)) > p.MaxResponseSize {
+		return nil, "", fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, p.MaxResponseSize)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// retryable reports whether a failed download may succeed if tried again: the server
+// answered with a 5xx status or the request timed out, while ctx is still running.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// hostLimiter bounds the downloads running at once against each host.
+type hostLimiter struct {
+	limit int // 0 for no limit
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquire waits for a free slot for the host of rawURL and returns the function releasing it.
+func (l *hostLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	if l.limit <= 0 {
+		return func() {}, nil
+	}
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+	l.mu.Lock()
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[host] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// externalType picks the content type of a download. The server's Content-Type wins unless
+// it is missing or generic; then the URL's file extension, the type the referencing element
+// expects and finally the data itself decide.
+func externalType(header string, ref externalRef, data []byte) string {
+	if header != "" && !genericTypes[normalizeContentType(header)] {
+		return header
+	}
+	if u, err := url.Parse(ref.URL); err == nil {
+		if t := mime.TypeByExtension(path.Ext(u.Path)); t != "" {
+			return t
+		}
+	}
+	if ref.Hint != "" {
+		return ref.Hint
+	}
+	if header != "" && normalizeContentType(header) == "text/plain" {
+		return header
+	}
+	return http.DetectContentType(data)
+}
+
+// documentRefs returns the network subresources of an HTML document, resolved against its
+// <base href>, its Content-Location or the archive's Snapshot-Content-Location.
+func (p *MHTMLParser) documentRefs(document Document) ([]externalRef, error) {
+	base := document.URL
+	if base == "" {
+		base = p.BaseURL
+	}
+	if base == "" {
+		base = p.Metadata.URL
+	}
+	doc, base, err := parseHTMLDocument(document.Content, base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	var refs []externalRef
+	add := func(ref, hint string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || strings.HasPrefix(ref, "#") {
+			return
+		}
+		if resolved := resolveURL(base, ref); isHTTPURL(resolved) {
+			refs = append(refs, externalRef{URL: resolved, Document: document.Section, Hint: hint})
+		}
+	}
+	addCSS := func(css string) {
+		for _, ref := range scanCSS(css) {
+			add(ref.Ref, cssHint(ref.Kind))
+		}
+	}
+
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		switch goquery.NodeName(s) {
+		case "script":
+			if src, ok := s.Attr("src"); ok {
+				add(src, "text/javascript")
+			}
+		case "link":
+			rel, _ := s.Attr("rel")
+			as, _ := s.Attr("as")
+			if hint, ok := linkHint(rel, as); ok {
+				if href, ok := s.Attr("href"); ok {
+					add(href, hint)
+				}
+			}
+		case "img", "source", "audio", "video", "track", "embed":
+			if src, ok := s.Attr("src"); ok {
+				add(src, "")
+			}
+		case "input":
+			if t, _ := s.Attr("type"); strings.EqualFold(t, "image") {
+				add(s.AttrOr("src", ""), "")
+			}
+		case "object":
+			if data, ok := s.Attr("data"); ok {
+				add(data, "")
+			}
+		case "style":
+			addCSS(s.Text())
+		}
+		if poster, ok := s.Attr("poster"); ok {
+			add(poster, "")
+		}
+		if background, ok := s.Attr("background"); ok {
+			add(background, "")
+		}
+		for _, attr := range []string{"srcset", "imagesrcset"} {
+			if value, ok := s.Attr(attr); ok {
+				rewriteSrcset(value, func(ref string) string {
+					add(ref, "")
+					return ref
+				})
+			}
+		}
+		if style, ok := s.Attr("style"); ok {
+			addCSS(style)
+		}
+	})
+	return refs, nil
+}
+
+// stylesheetRefs returns the network references of a downloaded stylesheet.
+func stylesheetRefs(css, base, section string) []externalRef {
+	var refs []externalRef
+	for _, ref := range scanCSS(css) {
+		value := strings.TrimSpace(ref.Ref)
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+		if resolved := resolveURL(base, value); isHTTPURL(resolved) {
+			refs = append(refs, externalRef{URL: resolved, Document: section, Hint: cssHint(ref.Kind)})
+		}
+	}
+	return refs
+}
+
+// linkHint returns the content type a <link> element loads, and false for links that are not
+// subresources of the page, such as hyperlinks, canonical URLs and prefetches of other pages.
+func linkHint(rel, as string) (string, bool) {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		switch r {
+		case "stylesheet":
+			return "text/css", true
+		case "modulepreload":
+			return "text/javascript", true
+		case "icon", "apple-touch-icon", "apple-touch-icon-precomposed", "mask-icon":
+			return "", true
+		case "manifest":
+			return "application/manifest+json", true
+		case "preload":
+			switch strings.ToLower(strings.TrimSpace(as)) {
+			case "style":
+				return "text/css", true
+			case "script":
+				return "text/javascript", true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// cssHint returns the content type expected for a CSS reference of the given kind.
+func cssHint(kind string) string {
+	if kind == CSSImport {
+		return "text/css"
+	}
+	return ""
+}
+
+// isHTTPURL reports whether raw is an absolute http or https URL.
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,230 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer starts a test server answering every request with body and counting them.
+func countingServer(t *testing.T, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// parseWithFetch parses a one-page archive whose HTML is page, fetching external resources.
+func parseWithFetch(t *testing.T, p *MHTMLParser, page string) {
+	t.Helper()
+	archive := "Content-Type: multipart/related; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html; charset=utf-8\r\nContent-Location: https://example.com/\r\n\r\n" +
+		page + "\r\n--b--\r\n"
+	if err := p.ParseReader(context.Background(), strings.NewReader(archive), ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+}
+
+// fetchWarnings returns the fetch warnings of p matching target.
+func fetchWarnings(p *MHTMLParser, target error) []Warning {
+	var out []Warning
+	for _, w := range p.Warnings {
+		if w.Stage == StageFetch && errors.Is(w, target) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// externalCount returns the number of downloaded resources of p.
+func externalCount(p *MHTMLParser) int {
+	n := 0
+	for _, res := range p.Resources {
+		if res.Source == "external" {
+			n++
+		}
+	}
+	return n
+}
+
+// newFetchingParser returns a parser fetching external resources from the local test servers.
+func newFetchingParser() *MHTMLParser {
+	p := New("", true)
+	p.FetchPolicy.AllowPrivate = true
+	return p
+}
+
+// externalURLs returns the URLs of the downloaded resources of p, in order.
+func externalURLs(p *MHTMLParser) []string {
+	var urls []string
+	for _, res := range p.Resources {
+		if res.Source == "external" {
+			urls = append(urls, res.URL)
+		}
+	}
+	return urls
+}
+
+func TestFetchExternalKeepsDocumentOrder(t *testing.T) {
+	delays := map[string]time.Duration{"/a.png": 150 * time.Millisecond, "/b.png": 100 * time.Millisecond, "/c.png": 50 * time.Millisecond}
+	var mu sync.Mutex
+	var finished []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delays[r.URL.Path])
+		mu.Lock()
+		finished = append(finished, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+
+	p := newFetchingParser()
+	p.FetchWorkers = 4
+	parseWithFetch(t, p, fmt.Sprintf(`<img src="%[1]s/a.png"><img src="%[1]s/b.png"><img src="%[1]s/c.png"><img src="%[1]s/d.png">`, srv.URL))
+	mu.Lock()
+	defer mu.Unlock()
+	if finished[0] != "/d.png" {
+		t.Fatalf("downloads finished in order %v, want /d.png first", finished)
+	}
+	want := []string{srv.URL + "/a.png", srv.URL + "/b.png", srv.URL + "/c.png", srv.URL + "/d.png"}
+	if got := externalURLs(p); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("resources are %v, want %v", got, want)
+	}
+	for _, res := range p.Resources {
+		if res.Source == "external" && !strings.HasSuffix(res.URL, string(res.Data)) {
+			t.Errorf("%s holds %q", res.URL, res.Data)
+		}
+	}
+}
+
+func TestFetchExternalRetries(t *testing.T) {
+	hits := make(map[string]int)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/missing.png":
+			http.NotFound(w, r)
+		case r.URL.Path == "/flaky.png" && n == 1:
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		case r.URL.Path == "/down.png":
+			http.Error(w, "down", http.StatusBadGateway)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, "png")
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	p := newFetchingParser()
+	p.FetchRetries = 1
+	parseWithFetch(t, p, fmt.Sprintf(`<img src="%[1]s/flaky.png"><img src="%[1]s/missing.png"><img src="%[1]s/down.png">`, srv.URL))
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/flaky.png"] != 2 || hits["/missing.png"] != 1 || hits["/down.png"] != 2 {
+		t.Errorf("requests per path = %v, want flaky 2, missing 1, down 2", hits)
+	}
+	if got := externalURLs(p); len(got) != 1 || got[0] != srv.URL+"/flaky.png" {
+		t.Errorf("downloaded %v, want only flaky.png", got)
+	}
+	var statuses []int
+	for _, w := range p.Warnings {
+		var statusErr *StatusError
+		if errors.As(w, &statusErr) {
+			statuses = append(statuses, statusErr.StatusCode)
+		}
+	}
+	if fmt.Sprint(statuses) != "[404 502]" {
+		t.Errorf("status warnings %v, want [404 502]", statuses)
+	}
+}
+
+func TestFetchExternalMaxResponseSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/chunked.png" {
+			// Flushing before the body leaves the response without a Content-Length.
+			w.(http.Flusher).Flush()
+		}
+		size := 100
+		if r.URL.Path == "/small.png" {
+			size = 50
+		}
+		io.WriteString(w, strings.Repeat("x", size))
+	}))
+	t.Cleanup(srv.Close)
+
+	p := newFetchingParser()
+	p.MaxResponseSize = 50
+	parseWithFetch(t, p, fmt.Sprintf(`<img src="%[1]s/sized.png"><img src="%[1]s/chunked.png"><img src="%[1]s/small.png">`, srv.URL))
+	if got := externalURLs(p); len(got) != 1 || got[0] != srv.URL+"/small.png" {
+		t.Errorf("downloaded %v, want only small.png", got)
+	}
+	// The Content-Length rejects the first response before its body is read; the second is
+	// cut off while reading.
+	var tooLarge []string
+	for _, w := range fetchWarnings(p, ErrResponseTooLarge) {
+		tooLarge = append(tooLarge, strings.TrimPrefix(w.URL, srv.URL)+": "+w.Err.Error())
+	}
+	want := "/sized.png: mhtmlparser: response exceeds MaxResponseSize: 100 bytes; " +
+		"/chunked.png: mhtmlparser: response exceeds MaxResponseSize: more than 50 bytes"
+	if got := strings.Join(tooLarge, "; "); got != want {
+		t.Errorf("oversized warnings %q, want %q", got, want)
+	}
+}
+
+func TestFetchExternalPerHostLimit(t *testing.T) {
+	var mu sync.Mutex
+	running := make(map[string]int)
+	peak := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Split(r.Host, ":")[0]
+		mu.Lock()
+		running[host]++
+		peak[host] = max(peak[host], running[host])
+		mu.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		running[host]--
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, "png")
+	}))
+	t.Cleanup(srv.Close)
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	var page strings.Builder
+	for i := 0; i < 6; i++ {
+		fmt.Fprintf(&page, `<img src="http://127.0.0.1:%[1]d/%[2]d.png"><img src="http://localhost:%[1]d/%[2]d.png">`, port, i)
+	}
+	p := newFetchingParser()
+	p.FetchWorkers = 8
+	p.FetchPerHost = 2
+	parseWithFetch(t, p, page.String())
+	if n := externalCount(p); n != 12 {
+		t.Fatalf("downloaded %d resources, want 12; warnings %v", n, p.Warnings)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak["127.0.0.1"] != 2 || peak["localhost"] != 2 {
+		t.Errorf("peak downloads per host = %v, want 2 each", peak)
+	}
+}
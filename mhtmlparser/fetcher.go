@@ -0,0 +1,145 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultFetchTimeout bounds each request of an HTTPFetcher whose options set no Timeout.
+const defaultFetchTimeout = 5 * time.Second
+
+// Fetcher performs the network requests of a parse, such as downloads of external
+// resources. Set MHTMLParser.Fetcher to stub, cache or reroute them. Fetch is called from
+// several goroutines at once, and the caller closes the body of the returned response.
+// Fetchers that open connections themselves are responsible for the address checks of
+// FetchPolicy, which HTTPFetcher makes.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (*http.Response, error)
+}
+
+// FetcherFunc adapts a function to the Fetcher interface.
+type FetcherFunc func(ctx context.Context, url string) (*http.Response, error)
+
+// Fetch calls f(ctx, url).
+func (f FetcherFunc) Fetch(ctx context.Context, url string) (*http.Response, error) {
+	return f(ctx, url)
+}
+
+// HTTPOptions configures the client of an HTTPFetcher.
+type HTTPOptions struct {
+	Client    *http.Client      // Used when set, in place of Transport, Jar, Proxy and Timeout
+	Transport http.RoundTripper // http.DefaultTransport when nil
+	UserAgent string            // User-Agent header, Go's default when empty
+	Header    http.Header       // Extra headers sent with every request
+	Jar       http.CookieJar    // Cookies to send and store, none when nil
+	Proxy     string            // Proxy URL such as "http://proxy:3128"; the HTTP_PROXY environment applies when empty
+	Timeout   time.Duration     // Limit for each request including its body, 5s when 0 and none when negative
+
+	// CacheDir is a directory keeping downloaded responses across parses, honoring their
+	// ETag, Last-Modified and Cache-Control headers; there is no cache when it is empty.
+	CacheDir string
+	// CacheOnly serves every request from CacheDir without using the network. Requests for
+	// resources that are not cached fail with ErrNotCached.
+	CacheOnly bool
+	// CacheMaxSize is the number of bytes CacheDir may hold; the least recently used
+	// responses are removed past it. There is no limit when it is 0.
+	CacheMaxSize int64
+}
+
+// HTTPFetcher is a Fetcher making GET requests with an http.Client.
+type HTTPFetcher struct {
+	client    *http.Client
+	userAgent string
+	header    http.Header
+}
+
+// NewHTTPFetcher returns an HTTPFetcher configured by opts. It fails when Proxy is not a
+// valid URL, or is set along with a Transport that is not an *http.Transport, and when
+// CacheOnly is set without a CacheDir.
+//
+// During a parse the fetcher enforces the parser's FetchPolicy: redirects are checked, and
+// the addresses host names resolve to are checked as connections are opened, unless the
+// transport is not an *http.Transport.
+func NewHTTPFetcher(opts HTTPOptions) (*HTTPFetcher, error) {
+	if opts.CacheOnly && opts.CacheDir == "" {
+		return nil, errors.New("CacheOnly needs a CacheDir")
+	}
+
+	var client http.Client
+	if opts.Client != nil {
+		client = *opts.Client
+	} else {
+		transport := opts.Transport
+		if transport == nil {
+			transport = defaultTransport()
+		}
+		if opts.Proxy != "" {
+			proxyURL, err := url.Parse(opts.Proxy)
+			if err != nil || proxyURL.Host == "" {
+				return nil, fmt.Errorf("invalid proxy URL %q", opts.Proxy)
+			}
+			base, ok := transport.(*http.Transport)
+			if !ok {
+				return nil, errors.New("a proxy needs an *http.Transport")
+			}
+			base = base.Clone()
+			base.Proxy = http.ProxyURL(proxyURL)
+			transport = base
+		}
+
+		timeout := opts.Timeout
+		switch {
+		case timeout == 0:
+			timeout = defaultFetchTimeout
+		case timeout < 0:
+			timeout = 0
+		}
+		client = http.Client{Transport: transport, Jar: opts.Jar, Timeout: timeout}
+	}
+
+	client.Transport = guardTransport(client.Transport)
+	client.CheckRedirect = checkRedirect(client.CheckRedirect)
+	if opts.CacheDir != "" {
+		client.Transport = newCacheTransport(opts.CacheDir, opts.CacheOnly, opts.CacheMaxSize, client.Transport)
+	}
+	return &HTTPFetcher{client: &client, userAgent: opts.UserAgent, header: opts.Header.Clone()}, nil
+}
+
+// Fetch sends a GET request for url with the configured headers.
+func (f *HTTPFetcher) Fetch(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range f.header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+	return f.client.Do(req)
+}
+
+// defaultTransport returns a copy of http.DefaultTransport without its dialer, which
+// guardTransport replaces with one checking addresses before connecting.
+func defaultTransport() http.RoundTripper {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return http.DefaultTransport
+	}
+	t := base.Clone()
+	t.DialContext = nil
+	return t
+}
+
+// defaultFetcher returns an HTTPFetcher with default options.
+func defaultFetcher() *HTTPFetcher {
+	f, _ := NewHTTPFetcher(HTTPOptions{}) // Cannot fail without a proxy or cache
+	return f
+}
@@ -0,0 +1,56 @@
+package mhtmlparser
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractLayout controls how ExtractResources arranges files under the output directory.
+type ExtractLayout int
+
+const (
+	// LayoutFlat writes every resource directly into the output directory.
+	LayoutFlat ExtractLayout = iota
+	// LayoutMirror recreates the host and path of each resource's URL under the output directory,
+	// e.g. example.com/static/css/app.css. Resources without an http(s) URL stay at the top level.
+	LayoutMirror
+)
+
+// relativePath returns where res is written relative to the output directory, using "/" separators.
+func (l ExtractLayout) relativePath(res Resource) string {
+	if l != LayoutMirror {
+		return res.Filename
+	}
+	u, err := url.Parse(strings.TrimSpace(res.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return res.Filename
+	}
+
+	segments := []string{sanitizeFilename(strings.ReplaceAll(u.Host, ":", "_"))}
+	// Cleaning a rooted path drops every ".." so the result cannot climb above the host directory.
+	clean := path.Clean("/" + u.Path)
+	for _, segment := range strings.Split(strings.Trim(clean, "/"), "/") {
+		if segment != "" {
+			segments = append(segments, sanitizeFilename(segment))
+		}
+	}
+	if len(segments) == 1 || strings.HasSuffix(u.Path, "/") {
+		segments = append(segments, "index")
+	}
+	last := len(segments) - 1
+	segments[last] = withExtension(segments[last], res.Type)
+	return strings.Join(segments, "/")
+}
+
+// safeJoin joins a relative slash-separated path onto dir and makes sure the result stays inside dir.
+func safeJoin(dir, rel string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+	within, err := filepath.Rel(dir, target)
+	if err != nil || within == ".." || strings.HasPrefix(within, ".."+string(filepath.Separator)) || filepath.IsAbs(within) {
+		return "", fmt.Errorf("path %q escapes the output directory", rel)
+	}
+	return target, nil
+}
@@ -0,0 +1,67 @@
+package mhtmlparser
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRelativePathMirror(t *testing.T) {
+	tests := []struct {
+		name string
+		res  Resource
+		want string
+	}{
+		{name: "path", res: Resource{URL: "https://example.com/static/css/app.css", Type: "text/css", Filename: "app.css"}, want: "example.com/static/css/app.css"},
+		{name: "host root", res: Resource{URL: "https://example.com", Type: "text/html", Filename: "page.html"}, want: "example.com/index.html"},
+		{name: "trailing slash", res: Resource{URL: "https://example.com/docs/", Type: "text/html", Filename: "docs.html"}, want: "example.com/docs/index.html"},
+		{name: "port", res: Resource{URL: "http://localhost:8080/a.png", Type: "image/png", Filename: "a.png"}, want: "localhost_8080/a.png"},
+		{name: "dot segments", res: Resource{URL: "https://example.com/a/../../../etc/passwd", Type: "text/plain", Filename: "passwd"}, want: "example.com/etc/passwd.txt"},
+		{name: "encoded slashes", res: Resource{URL: "https://example.com/a%2F..%2F..%2F..%2Fsecret.js", Type: "text/javascript", Filename: "secret.js"}, want: "example.com/secret.js"},
+		{name: "encoded dots", res: Resource{URL: "https://example.com/%2E%2E/%2e%2e/x.png", Type: "image/png", Filename: "x.png"}, want: "example.com/x.png"},
+		{name: "backslashes", res: Resource{URL: `https://example.com/..\..\x.png`, Type: "image/png", Filename: "x.png"}, want: "example.com/.._.._x.png"},
+		{name: "query dropped", res: Resource{URL: "https://example.com/img?id=1", Type: "image/png", Filename: "img.png"}, want: "example.com/img.png"},
+		{name: "missing extension", res: Resource{URL: "https://example.com/fonts/roboto", Type: "font/woff2", Filename: "roboto.woff2"}, want: "example.com/fonts/roboto.woff2"},
+		{name: "cid", res: Resource{URL: "cid:logo@example.com", Type: "image/png", Filename: "logo.png"}, want: "logo.png"},
+		{name: "data URI", res: Resource{URL: "data:image/png;base64,AAAA", Type: "image/png", Filename: "data_uri_1.png"}, want: "data_uri_1.png"},
+		{name: "file", res: Resource{URL: "file:///etc/passwd", Type: "text/plain", Filename: "passwd.txt"}, want: "passwd.txt"},
+		{name: "no URL", res: Resource{Type: "text/css", Filename: "inline_style_1.css"}, want: "inline_style_1.css"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LayoutMirror.relativePath(tt.res); got != tt.want {
+				t.Errorf("relativePath(%s) = %q, want %q", tt.res.URL, got, tt.want)
+			}
+			if got := LayoutFlat.relativePath(tt.res); got != tt.res.Filename {
+				t.Errorf("flat relativePath(%s) = %q, want %q", tt.res.URL, got, tt.res.Filename)
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	tests := []struct {
+		rel  string
+		want string // "" if the path must be rejected
+	}{
+		{rel: "example.com/a.png", want: filepath.Join(dir, "example.com", "a.png")},
+		{rel: "a/../b.png", want: filepath.Join(dir, "b.png")},
+		{rel: "..a.png", want: filepath.Join(dir, "..a.png")},
+		{rel: "..", want: ""},
+		{rel: "../out-other/a.png", want: ""},
+		{rel: "a/../../b.png", want: ""},
+		{rel: "../../etc/passwd", want: ""},
+	}
+	for _, tt := range tests {
+		got, err := safeJoin(dir, tt.rel)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("safeJoin(%q) = %q, want an error", tt.rel, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("safeJoin(%q) = %q, %v, want %q", tt.rel, got, err, tt.want)
+		}
+	}
+}
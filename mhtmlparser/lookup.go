@@ -0,0 +1,71 @@
+package mhtmlparser
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Lookup resolves ref to a parsed resource. ref may be an absolute URL, a URL relative
+// to BaseURL, or a cid: reference to a part's Content-ID. It returns nil when nothing matches.
+func (p *MHTMLParser) Lookup(ref string) *Resource {
+	return p.LookupFrom(ref, p.BaseURL)
+}
+
+// LookupFrom is like Lookup but resolves relative references against base, such as the
+// Content-Location of the stylesheet that contains them.
+func (p *MHTMLParser) LookupFrom(ref, base string) *Resource {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil
+	}
+
+	if len(ref) > 4 && strings.EqualFold(ref[:4], "cid:") {
+		cid := ref[4:]
+		if unescaped, err := url.PathUnescape(cid); err == nil {
+			cid = unescaped
+		}
+		cid = trimContentID(cid)
+		for i := range p.Resources {
+			if p.Resources[i].ContentID != "" && strings.EqualFold(p.Resources[i].ContentID, cid) {
+				return &p.Resources[i]
+			}
+		}
+	}
+
+	target := resolveURL(base, ref)
+	for i := range p.Resources {
+		if p.Resources[i].URL != "" && normalizeURL(p.Resources[i].URL) == target {
+			return &p.Resources[i]
+		}
+	}
+	return nil
+}
+
+// resolveURL resolves ref against base and normalizes the result.
+// Unparseable input is returned trimmed so it can still match verbatim.
+func resolveURL(base, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	if !u.IsAbs() && base != "" {
+		if b, err := url.Parse(base); err == nil {
+			u = b.ResolveReference(u)
+		}
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// normalizeURL drops the fragment of a URL so that "a.css#x" and "a.css" compare equal.
+func normalizeURL(raw string) string {
+	return resolveURL("", strings.TrimSpace(raw))
+}
+
+// trimContentID strips whitespace and the angle brackets around a Content-ID value.
+func trimContentID(id string) string {
+	id = strings.TrimSpace(id)
+	id = strings.TrimPrefix(id, "<")
+	return strings.TrimSuffix(id, ">")
+}
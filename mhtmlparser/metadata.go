@@ -0,0 +1,78 @@
+package mhtmlparser
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// Metadata holds the archive-level headers written by the browser that saved the page.
+type Metadata struct {
+	URL         string               `json:"url,omitempty"`         // Snapshot-Content-Location, the address of the saved page
+	Title       string               `json:"title,omitempty"`       // Decoded Subject, or the main document's <title> when missing
+	Date        time.Time            `json:"date,omitzero"`         // When the page was saved
+	Generator   string               `json:"generator,omitempty"`   // Program that wrote the archive, e.g. "Saved by Blink"
+	MIMEVersion string               `json:"mimeVersion,omitempty"` // MIME-Version header
+	Headers     textproto.MIMEHeader `json:"headers,omitempty"`     // Every top-level header as read, encoded words left intact
+}
+
+// wordDecoder decodes RFC 2047 encoded words in any charset known to the HTML charset registry.
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(label string, input io.Reader) (io.Reader, error) {
+		return charset.NewReaderLabel(label, input)
+	},
+}
+
+// parseMetadata builds Metadata from the top-level MIME header of an archive.
+func parseMetadata(header textproto.MIMEHeader) Metadata {
+	meta := Metadata{
+		URL:         strings.TrimSpace(header.Get("Snapshot-Content-Location")),
+		Title:       decodeHeader(header.Get("Subject")),
+		MIMEVersion: strings.TrimSpace(header.Get("MIME-Version")),
+		Headers:     header,
+	}
+	if date := header.Get("Date"); date != "" {
+		if t, err := mail.ParseDate(date); err == nil {
+			meta.Date = t
+		}
+	}
+	for _, key := range []string{"X-Generator", "X-MimeOLE", "From"} {
+		if v := decodeHeader(header.Get(key)); v != "" {
+			meta.Generator = strings.Trim(v, "<> ")
+			break
+		}
+	}
+	return meta
+}
+
+// decodeHeader decodes RFC 2047 encoded words, returning the raw value if decoding fails.
+func decodeHeader(value string) string {
+	value = strings.TrimSpace(value)
+	if decoded, err := wordDecoder.DecodeHeader(value); err == nil {
+		return decoded
+	}
+	return value
+}
+
+// documentTitle returns the text of the first <title> element of an HTML document.
+func documentTitle(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Find("title").First().Text())
+}
+
+// WriteMetadata writes the archive metadata to w as indented JSON.
+func (p *MHTMLParser) WriteMetadata(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.Metadata)
+}
@@ -2,6 +2,7 @@ package mhtmlparser
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	//"bytes"
 	"fmt"
@@ -13,200 +14,414 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/google/uuid"
 )
 
 // Resource represents an extracted resource from an MHTML file.
 type Resource struct {
-	Type     string
-	Filename string
-	Data     []byte
-	Size     int
-	Source   string // embedded, inline, external
+	Type      string
+	Filename  string
+	Data      []byte
+	Size      int
+	Source    string               // embedded, inline, data-uri, external
+	Charset   string               // Charset the part was stored in before being transcoded to UTF-8
+	URL       string               // Content-Location, or the download URL for external resources
+	ContentID string               // Content-ID without the surrounding angle brackets
+	Header    textproto.MIMEHeader // Original part headers, nil for resources that are not archive parts
+	Section   string               // IMAP-style part number such as "2.1", empty for resources that are not archive parts
+	Document  string               // Section of the HTML document, or stylesheet for data: URIs, a non-part resource was found in
+	Element   string               // Element and attribute holding a data: URI, e.g. "img#logo[src]"; empty in stylesheets
+	spillPath string               // Temporary file holding the data when it was too large to keep in memory
 }
 
 // MHTMLParser handles parsing and extraction of MHTML file resources.
 type MHTMLParser struct {
-	InputFile     string
-	FetchExternal bool
-	HTMLContent   string
-	Resources     []Resource
-	client        *http.Client // For external resource fetching
+	InputFile      string
+	FetchExternal  bool
+	Metadata       Metadata       // Archive-level headers such as the page URL, title and save date
+	HTMLContent    string         // Content of the main document, Documents[0]
+	Documents      []Document     // HTML documents in the archive: the main page first, then its frames
+	Resources      []Resource     // Embedded parts in archive order, followed by inline, data-uri and external resources
+	BaseURL        string         // Content-Location of the main document, used to resolve relative references
+	Root           *PartNode      // MIME structure of the archive, including nested multipart containers
+	Warnings       []Warning      // Recoverable problems met by the last parse
+	SpillThreshold int64          // Parts larger than this many bytes go to temp files instead of Data; 0 keeps all in memory
+	TempDir        string         // Directory for spilled parts, os.TempDir() when empty
+	Layout         ExtractLayout  // How ExtractResources arranges files, LayoutFlat by default
+	OnProgress     func(Progress) // Called as Parse and ExtractResources make progress; runs on their goroutine
+
+	// External fetching. New sets the defaults; zero values mean no limit, except that
+	// FetchWorkers below 1 downloads one resource at a time.
+	FetchWorkers    int         // Downloads running at once
+	FetchPerHost    int         // Downloads running at once against a single host
+	FetchRetries    int         // Extra attempts after a 5xx response or a timeout, with exponential backoff
+	MaxResponseSize int64       // Downloads larger than this many bytes fail with ErrResponseTooLarge
+	Fetcher         Fetcher     // Performs the requests; New sets an HTTPFetcher with default options
+	FetchPolicy     FetchPolicy // Where downloads may go and how much they may take; blocks non-public addresses by default
+
+	spilled   []string      // Temporary files created for spilled parts
+	onWarning func(Warning) // OnWarning of the running ParseReader call
+	progress  Progress      // State passed to OnProgress
 }
 
+// Defaults New sets for external fetching.
+const (
+	defaultFetchWorkers    = 8
+	defaultFetchPerHost    = 4
+	defaultFetchRetries    = 2
+	defaultMaxResponseSize = 64 << 20
+)
+
 // New creates a new MHTMLParser instance.
 func New(inputFile string, fetchExternal bool) *MHTMLParser {
 	return &MHTMLParser{
-		InputFile:     inputFile,
-		FetchExternal: fetchExternal,
-		client: &http.Client{
-			Timeout: 5 * time.Second, // Set timeout for HTTP requests
-		},
+		InputFile:       inputFile,
+		FetchExternal:   fetchExternal,
+		FetchWorkers:    defaultFetchWorkers,
+		FetchPerHost:    defaultFetchPerHost,
+		FetchRetries:    defaultFetchRetries,
+		MaxResponseSize: defaultMaxResponseSize,
+		Fetcher:         defaultFetcher(),
 	}
 }
 
+// ParseOptions configures ParseReader.
+type ParseOptions struct {
+	// OnPart is called with each MIME part as soon as it has been read and decoded.
+	// Returning ErrStop ends parsing without an error; any other error aborts it.
+	// Duplicate filenames are only given numeric suffixes once parsing ends, also after ErrStop.
+	OnPart func(index int, res *Resource) error
+	// Discard drops parts once OnPart has seen them instead of collecting them in Resources.
+	// The temp files of spilled parts are removed as soon as OnPart returns.
+	Discard bool
+	// OnWarning is called with each warning as it is recorded in Warnings.
+	OnWarning func(Warning)
+	// Size is the archive size in bytes if known, used for Progress.TotalBytes.
+	Size int64
+}
+
 // Parse reads and parses the MHTML file, extracting embedded resources and HTML content.
 func (p *MHTMLParser) Parse() error {
+	return p.ParseContext(context.Background())
+}
+
+// ParseContext is like Parse but stops when ctx is done and reports progress to OnProgress.
+func (p *MHTMLParser) ParseContext(ctx context.Context) error {
 	file, err := os.Open(p.InputFile)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	var opts ParseOptions
+	if info, err := file.Stat(); err == nil {
+		opts.Size = info.Size()
+	}
+	return p.ParseReader(ctx, file, opts)
+}
+
+// ParseReader parses an MHTML archive from r, such as stdin or an HTTP body.
+// Parts are handed to opts.OnPart while the archive is read, and parsing stops when ctx is done.
+func (p *MHTMLParser) ParseReader(ctx context.Context, r io.Reader, opts ParseOptions) error {
+	p.progress = Progress{Stage: StageRead, TotalBytes: opts.Size}
+	reader := bufio.NewReader(&contextReader{ctx: ctx, r: r, p: p})
 	header, err := textproto.NewReader(reader).ReadMIMEHeader()
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		return fmt.Errorf("failed to read MIME header: %w", err)
 	}
 
 	contentType := header.Get("Content-Type")
-	_, params, err := mime.ParseMediaType(contentType)
+	mediaType, params, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		return fmt.Errorf("failed to parse media type: %w", err)
+		return fmt.Errorf("%w: failed to parse media type: %v", ErrNotMultipart, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("%w: top-level type is %s", ErrNotMultipart, mediaType)
+	}
+	if params["boundary"] == "" {
+		return ErrNoBoundary
 	}
 
 	mr := multipart.NewReader(reader, params["boundary"])
+	p.Warnings = nil
+	p.onWarning = opts.OnWarning
+	defer func() { p.onWarning = nil }()
+	if err := p.Close(); err != nil {
+		p.warn(Warning{Part: -1, Stage: StageCleanup, Err: err})
+	}
 	p.Resources = []Resource{}
+	p.Metadata = parseMetadata(header)
+	p.HTMLContent = ""
+	p.BaseURL = ""
+	p.Documents = nil
+	p.Root = &PartNode{Type: mediaType, Header: header}
+
+	var index int
+	defer func() { makeFilenamesUnique(p.Resources) }()
+	err = p.readMultipart(ctx, mr, p.Root, opts, &index)
+	p.pickMainDocument(p.Metadata.URL)
+	if p.Metadata.Title == "" && p.HTMLContent != "" {
+		p.Metadata.Title = documentTitle(p.HTMLContent)
+	}
+	if err != nil {
+		if errors.Is(err, ErrStop) {
+			return nil
+		}
+		return err
+	}
+	if index == 0 && len(p.Warnings) > 0 && p.Root.Children == nil {
+		return fmt.Errorf("%w: no part found for boundary %q", ErrNoBoundary, params["boundary"])
+	}
+	p.reportProgress()
+
+	// Extract inline scripts and styles from the main document and every frame
+	inlineCounts := make(map[string]int)
+	for _, doc := range p.Documents {
+		if inline, err := p.extractInline(doc, inlineCounts); err == nil {
+			p.Resources = append(p.Resources, inline...)
+		} else {
+			p.warn(Warning{Part: -1, Section: doc.Section, Stage: StageInline, URL: doc.URL, Err: err})
+		}
+	}
+	// Images, fonts and other files kept as data: URIs in the HTML and CSS
+	p.Resources = append(p.Resources, p.extractDataURIs()...)
+
+	if p.FetchExternal {
+		p.Resources = append(p.Resources, p.fetchExternal(ctx)...)
+		return ctx.Err()
+	}
+	return nil
+}
 
-	for {
-		part, err := mr.NextPart()
-		if errors.Is(err, io.EOF) {
-			break
+// maxNestingDepth bounds how deep nested multipart bodies are followed.
+const maxNestingDepth = 16
+
+// readMultipart reads the parts of mr into Resources, recording the MIME structure under parent.
+// Nested multipart bodies are descended into; index counts the leaf parts handed to opts.OnPart.
+func (p *MHTMLParser) readMultipart(ctx context.Context, mr *multipart.Reader, parent *PartNode, opts ParseOptions, index *int) error {
+	for i := 1; ; i++ {
+		// NextRawPart leaves Content-Transfer-Encoding to newTransferDecoder.
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
 		}
 		if err != nil {
-			fmt.Printf("Warning: failed to read MIME part: %v\n", err)
-			continue
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				err = fmt.Errorf("%w: closing boundary missing", ErrTruncated)
+			}
+			// The multipart reader cannot recover from a broken boundary, so keep what was read so far.
+			p.warn(Warning{Part: -1, Section: parent.Section, Stage: StageRead, Err: err})
+			return nil
 		}
 
-		contentType := normalizeContentType(part.Header.Get("Content-Type"))
-		filename := part.FileName()
-		if filename == "" {
-			filename = fmt.Sprintf("resource_%s%s", randomID(), extensionFor(contentType))
-		} else {
-			filename = sanitizeFilename(filename)
+		section := strconv.Itoa(i)
+		if parent.Section != "" {
+			section = parent.Section + "." + section
 		}
 
-		data, err := io.ReadAll(part)
-		if err != nil {
-			fmt.Printf("Warning: failed to read part %s: %v\n", filename, err)
+		mediaType, params, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && strings.Count(section, ".") < maxNestingDepth {
+			node := &PartNode{Section: section, Type: mediaType, Header: part.Header, Parent: parent}
+			parent.Children = append(parent.Children, node)
+			body := newTransferDecoder(part, part.Header.Get("Content-Transfer-Encoding"))
+			if err := p.readMultipart(ctx, multipart.NewReader(body, params["boundary"]), node, opts, index); err != nil {
+				return err
+			}
 			continue
 		}
 
-		if strings.HasPrefix(contentType, "text/html") {
-			p.HTMLContent = string(data)
-			filename = fmt.Sprintf("page_%s.html", randomID())
-			p.Resources = append(p.Resources, Resource{
-				Type:     contentType,
-				Filename: filename,
-				Data:     data,
-				Size:     len(data),
-				Source:   "embedded",
-			})
-		} else {
-			p.Resources = append(p.Resources, Resource{
-				Type:     contentType,
-				Filename: filename,
-				Data:     data,
-				Size:     len(data),
-				Source:   "embedded",
-			})
+		res, err := p.readPart(part, *index, section)
+		*index++
+		p.progress.Parts = *index
+		p.reportProgress()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			p.warn(Warning{Part: *index - 1, Section: section, Stage: StageRead, URL: res.URL, Err: err})
+			continue
 		}
-	}
+		parent.Children = append(parent.Children, &PartNode{Section: section, Type: res.Type, Header: part.Header, Parent: parent})
 
-	// Extract inline and external JavaScript
-	if p.HTMLContent != "" {
-		if scripts, err := p.extractInlineScripts(); err == nil {
-			p.Resources = append(p.Resources, scripts...)
-		} else {
-			fmt.Printf("Warning: failed to extract inline scripts: %v\n", err)
+		if strings.HasPrefix(res.Type, "text/html") {
+			p.Documents = append(p.Documents, Document{URL: res.URL, Section: section, Content: string(res.Data)})
 		}
-		if p.FetchExternal {
-			if scripts, err := p.downloadExternalScripts(); err == nil {
-				p.Resources = append(p.Resources, scripts...)
-			} else {
-				fmt.Printf("Warning: failed to download external scripts: %v\n", err)
-			}
+		var stop error
+		if opts.OnPart != nil {
+			stop = opts.OnPart(*index-1, &res)
+		}
+		if !opts.Discard {
+			p.Resources = append(p.Resources, res)
+		} else if err := p.removeSpill(&res); err != nil {
+			p.warn(Warning{Part: *index - 1, Section: section, Stage: StageCleanup, URL: res.URL, Err: err})
+		}
+		if stop != nil {
+			return stop
 		}
 	}
+}
 
-	return nil
+// readPart decodes a single MIME part into a Resource. Corrupt and truncated bodies are kept
+// with a warning; an error is returned only when the part could not be read at all.
+func (p *MHTMLParser) readPart(part *multipart.Part, index int, section string) (Resource, error) {
+	rawContentType := part.Header.Get("Content-Type")
+	contentType := normalizeContentType(rawContentType)
+	res := Resource{
+		Type:      contentType,
+		Source:    "embedded",
+		URL:       part.Header.Get("Content-Location"),
+		ContentID: trimContentID(part.Header.Get("Content-ID")),
+		Header:    part.Header,
+		Section:   section,
+	}
+	err := p.readBody(newTransferDecoder(part, part.Header.Get("Content-Transfer-Encoding")), rawContentType, index, &res)
+	prefix := "resource"
+	if contentType == "text/html" {
+		prefix = "page"
+	}
+	res.Filename = deriveFilename(res, part.FileName(), prefix)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrCorruptBody):
+		p.warn(Warning{Part: index, Section: section, Stage: StageDecode, URL: res.URL, Err: err})
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		err = fmt.Errorf("%w: part body cut off after %d bytes", ErrTruncated, max(len(res.Data), res.Size))
+		p.warn(Warning{Part: index, Section: section, Stage: StageRead, URL: res.URL, Err: err})
+	default:
+		return res, err
+	}
+	if res.Spilled() {
+		return res, nil
+	}
+
+	res.Data, res.Charset, err = decodeCharset(res.Data, rawContentType, contentType)
+	if err != nil {
+		p.warn(Warning{Part: index, Section: section, Stage: StageCharset, URL: res.URL, Err: err})
+	}
+	res.Size = len(res.Data)
+	return res, nil
 }
 
-// extractInlineScripts extracts inline JavaScript from <script> tags without src attributes.
-func (p *MHTMLParser) extractInlineScripts() ([]Resource, error) {
+// extractInline extracts the bodies of <script> tags without src attributes and of <style> tags,
+// and gathers the style attributes of the document into one stylesheet. Scripts are typed by
+// their type attribute. Resources are numbered per kind in document order, continuing from counts
+// across documents.
+func (p *MHTMLParser) extractInline(document Document, counts map[string]int) ([]Resource, error) {
 	var results []Resource
+	add := func(kind inlineKind, code string) {
+		counts[kind.name]++
+		data := []byte(code)
+		results = append(results, Resource{
+			Type:     kind.contentType,
+			Filename: fmt.Sprintf("inline_%s_%d%s", kind.name, counts[kind.name], kind.ext),
+			Data:     data,
+			Size:     len(data),
+			Source:   "inline",
+			Document: document.Section,
+		})
+	}
 
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.HTMLContent))
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(document.Content))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse HTML: %w", err)
 	}
 
-	doc.Find("script").Each(func(i int, s *goquery.Selection) {
-		if _, exists := s.Attr("src"); !exists {
-			code := strings.TrimSpace(s.Text())
-			if code != "" {
-				data := []byte(code)
-				results = append(results, Resource{
-					Type:     "text/javascript",
-					Filename: fmt.Sprintf("inline_script_%s.js.random", randomID()),
-					Data:     data,
-					Size:     len(data),
-					Source:   "inline",
-				})
-			}
+	doc.Find("script, style").Each(func(i int, s *goquery.Selection) {
+		if _, exists := s.Attr("src"); exists {
+			return
+		}
+		code := strings.TrimSpace(s.Text())
+		if code == "" {
+			return
 		}
+		if goquery.NodeName(s) == "style" {
+			add(inlineKind{"style", "text/css", ".css"}, code)
+			return
+		}
+		scriptType, _ := s.Attr("type")
+		add(classifyScript(scriptType), code)
 	})
-	return results, nil
-}
-
-// downloadExternalScripts downloads external JavaScript from <script src="..."> tags.
-func (p *MHTMLParser) downloadExternalScripts() ([]Resource, error) {
-	var results []Resource
-	scriptRe := regexp.MustCompile(`(?i)<script[^>]+src=["'](https?://[^"']+)["']`)
 
-	matches := scriptRe.FindAllStringSubmatch(p.HTMLContent, -1)
-	for _, match := range matches {
-		url := match[1]
-		resp, err := p.client.Get(url)
-		if err != nil {
-			fmt.Printf("Warning: failed to download %s: %v\n", url, err)
-			continue
+	// Style attributes are not stylesheets on their own, so each becomes a rule for a
+	// selector approximating its element.
+	var rules strings.Builder
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		style, _ := s.Attr("style")
+		if style = strings.TrimSpace(style); style != "" {
+			fmt.Fprintf(&rules, "%s { %s }\n", elementSelector(s), style)
 		}
-		defer resp.Body.Close()
+	})
+	if rules.Len() > 0 {
+		add(inlineKind{"style_attrs", "text/css", ".css"}, rules.String())
+	}
+	return results, nil
+}
 
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("Warning: failed to download %s: status %d\n", url, resp.StatusCode)
-			continue
-		}
+// inlineKind describes how an inline block is stored as a resource.
+type inlineKind struct {
+	name        string // Filename stem, e.g. "script" for inline_script_1.js
+	contentType string
+	ext         string
+}
 
-		data, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("Warning: failed to read response body for %s: %v\n", url, err)
-			continue
-		}
+// classifyScript picks the kind of an inline <script> block from its type attribute, so that
+// modules, JSON, JSON-LD, SVG and templates are not all stored as JavaScript.
+func classifyScript(typeAttr string) inlineKind {
+	t := strings.ToLower(strings.TrimSpace(typeAttr))
+	if mediaType, _, err := mime.ParseMediaType(t); err == nil {
+		t = mediaType
+	}
+	switch {
+	case t == "" || strings.HasPrefix(t, "text/javascript") || strings.Contains(t, "ecmascript") ||
+		t == "application/javascript" || t == "application/x-javascript" || t == "text/jscript":
+		return inlineKind{"script", "text/javascript", ".js"}
+	case t == "module":
+		return inlineKind{"module", "text/javascript", ".mjs"}
+	case t == "application/ld+json":
+		return inlineKind{"jsonld", "application/ld+json", ".jsonld"}
+	case t == "importmap" || t == "speculationrules" || t == "application/json" || strings.HasSuffix(t, "+json"):
+		return inlineKind{"json", "application/json", ".json"}
+	case t == "image/svg+xml":
+		return inlineKind{"svg", "image/svg+xml", ".svg"}
+	case t == "text/html" || strings.Contains(t, "template") || strings.Contains(t, "handlebars") || strings.Contains(t, "mustache"):
+		return inlineKind{"template", "text/html", ".html"}
+	case strings.Contains(t, "/") && extensionFor(t) != ".bin":
+		return inlineKind{"data", t, extensionFor(t)}
+	}
+	return inlineKind{"data", "text/plain", ".txt"}
+}
 
-		name := sanitizeFilename(filepath.Base(strings.Split(url, "?")[0]))
-		if name == "" || !strings.HasSuffix(name, ".js") {
-			name = fmt.Sprintf("script_%s.js", randomID())
+// elementSelector returns a CSS selector made of the element's tag, id and classes.
+func elementSelector(s *goquery.Selection) string {
+	selector := goquery.NodeName(s)
+	if id, ok := s.Attr("id"); ok && id != "" {
+		selector += "#" + id
+	}
+	if class, ok := s.Attr("class"); ok {
+		for _, c := range strings.Fields(class) {
+			selector += "." + c
 		}
-
-		results = append(results, Resource{
-			Type:     "text/javascript",
-			Filename: name,
-			Data:     data,
-			Size:     len(data),
-			Source:   "external",
-		})
 	}
-	return results, nil
+	return selector
 }
 
 // ExtractResources saves resources to the output directory.
 func (p *MHTMLParser) ExtractResources(outputDir string, selected []int) ([]string, error) {
+	return p.ExtractResourcesContext(context.Background(), outputDir, selected)
+}
+
+// ExtractResourcesContext is like ExtractResources but stops when ctx is done and reports
+// progress to OnProgress. Paths written before cancellation are returned with the error.
+func (p *MHTMLParser) ExtractResourcesContext(ctx context.Context, outputDir string, selected []int) ([]string, error) {
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
@@ -220,26 +435,105 @@ func (p *MHTMLParser) ExtractResources(outputDir string, selected []int) ([]stri
 		selectedSet[idx] = struct{}{}
 	}
 
+	var indices []int
+	for i := range p.Resources {
+		if selected == nil || contains(selectedSet, i) {
+			indices = append(indices, i)
+		}
+	}
+	return p.extract(ctx, outputDir, indices, nil)
+}
+
+// contentRewriter returns replacement content for the resource at index i, or nil to copy it as-is.
+// planned maps the index of every resource being written to its output path.
+type contentRewriter func(i int, planned map[int]string) ([]byte, error)
+
+// extract writes the resources at indices under outputDir, passing each through rewrite if set.
+func (p *MHTMLParser) extract(ctx context.Context, outputDir string, indices []int, rewrite contentRewriter) ([]string, error) {
+	p.progress = Progress{Stage: StageWrite, TotalWrites: len(indices)}
+	p.reportProgress()
+
+	planned, err := p.planPaths(outputDir, indices)
+	if err != nil {
+		return nil, err
+	}
+
 	var paths []string
-	for i, res := range p.Resources {
-		if selected != nil && !contains(selectedSet, i) {
-			continue
+	for _, i := range indices {
+		if err := ctx.Err(); err != nil {
+			return paths, err
+		}
+		outputPath := planned[i]
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return paths, fmt.Errorf("failed to create directory for %s: %w", outputPath, err)
+		}
+
+		res := p.Resources[i]
+		if rewrite != nil {
+			data, err := rewrite(i, planned)
+			if err != nil {
+				return paths, fmt.Errorf("failed to rewrite %s: %w", res.Filename, err)
+			}
+			if data != nil {
+				res.Data, res.spillPath = data, ""
+			}
+		}
+		if err := writeResource(ctx, outputPath, res); err != nil {
+			return paths, fmt.Errorf("failed to write %s: %w", outputPath, err)
+		}
+		paths = append(paths, outputPath)
+		p.progress.Written++
+		p.reportProgress()
+	}
+	return paths, nil
+}
+
+// planPaths decides where each resource at indices is written under outputDir according to
+// Layout, adding numeric suffixes to avoid existing files and clashes within the selection.
+func (p *MHTMLParser) planPaths(outputDir string, indices []int) (map[int]string, error) {
+	planned := make(map[int]string, len(indices))
+	taken := make(map[string]bool, len(indices))
+	for _, i := range indices {
+		outputPath, err := safeJoin(outputDir, p.Layout.relativePath(p.Resources[i]))
+		if err != nil {
+			return nil, err
 		}
-		outputPath := filepath.Join(outputDir, res.Filename)
 		// Avoid collisions
 		base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
 		ext := filepath.Ext(outputPath)
 		counter := 1
-		for fileExists(outputPath) {
+		for fileExists(outputPath) || taken[strings.ToLower(outputPath)] {
 			outputPath = fmt.Sprintf("%s_%d%s", base, counter, ext)
 			counter++
 		}
-		if err := os.WriteFile(outputPath, res.Data, 0644); err != nil {
-			return nil, fmt.Errorf("failed to write %s: %w", outputPath, err)
-		}
-		paths = append(paths, outputPath)
+		taken[strings.ToLower(outputPath)] = true
+		planned[i] = outputPath
 	}
-	return paths, nil
+	return planned, nil
+}
+
+// writeResource streams the resource data to path, giving up when ctx is done.
+func writeResource(ctx context.Context, path string, res Resource) error {
+	src, err := res.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, &contextReader{ctx: ctx, r: src}); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// get requests url through the parser's Fetcher. Every network access goes through it.
+func (p *MHTMLParser) get(ctx context.Context, url string) (*http.Response, error) {
+	return p.Fetcher.Fetch(ctx, url)
 }
 
 // GetHTMLContent returns the HTML content of the MHTML file.
@@ -248,6 +542,7 @@ func (p *MHTMLParser) GetHTMLContent() string {
 }
 
 // normalizeContentType normalizes content types by converting to lowercase and stripping parameters.
+// The charset parameter is handled separately by decodeCharset.
 func normalizeContentType(contentType string) string {
 	if contentType == "" {
 		return "application/octet-stream"
@@ -259,17 +554,19 @@ func normalizeContentType(contentType string) string {
 func sanitizeFilename(name string) string {
 	name = strings.ReplaceAll(name, string(os.PathSeparator), "_")
 	name = regexp.MustCompile(`[^a-zA-Z0-9._-]`).ReplaceAllString(name, "_")
+	if len(name) > maxFilenameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = name[:maxFilenameLength-len(ext)] + ext
+	}
 	if name == "" || name == "." || name == ".." {
-		return fmt.Sprintf("resource_%s", randomID())
+		return "resource"
 	}
 	return name
 }
 
-// randomID generates a unique 8-character ID.
-func randomID() string {
-	return uuid.New().String()[:8]
-}
-
 // extensionFor maps content types to file extensions.
 func extensionFor(contentType string) string {
 	switch contentType {
@@ -281,12 +578,20 @@ func extensionFor(contentType string) string {
 		return ".webp"
 	case "image/gif":
 		return ".gif"
+	case "image/svg+xml":
+		return ".svg"
+	case "image/avif":
+		return ".avif"
+	case "image/x-icon", "image/vnd.microsoft.icon":
+		return ".ico"
 	case "text/css":
 		return ".css"
 	case "text/javascript", "application/javascript":
 		return ".js"
 	case "application/json":
 		return ".json"
+	case "application/ld+json":
+		return ".jsonld"
 	case "font/ttf":
 		return ".ttf"
 	case "font/otf":
@@ -0,0 +1,71 @@
+package mhtmlparser
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseReaderStopKeepsFilenamesUnique(t *testing.T) {
+	archive := "Content-Type: multipart/related; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Location: http://a.example/logo.png\r\n\r\none\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Location: http://b.example/logo.png\r\n\r\ntwo\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Location: http://c.example/logo.png\r\n\r\nthree\r\n" +
+		"--b--\r\n"
+	p := New("", false)
+	opts := ParseOptions{OnPart: func(index int, _ *Resource) error {
+		if index == 1 {
+			return ErrStop
+		}
+		return nil
+	}}
+	if err := p.ParseReader(context.Background(), strings.NewReader(archive), opts); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+	if len(p.Resources) != 2 {
+		t.Fatalf("kept %d resources, want 2", len(p.Resources))
+	}
+	if a, b := p.Resources[0].Filename, p.Resources[1].Filename; a == b {
+		t.Errorf("both resources are named %q", a)
+	}
+}
+
+func TestParseReaderDiscardRemovesSpilledParts(t *testing.T) {
+	var archive strings.Builder
+	archive.WriteString("Content-Type: multipart/related; boundary=b\r\n\r\n")
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&archive, "--b\r\nContent-Type: application/octet-stream\r\n\r\n%s\r\n", strings.Repeat("x", 4096))
+	}
+	archive.WriteString("--b--\r\n")
+
+	p := New("", false)
+	p.SpillThreshold = 1024
+	p.TempDir = t.TempDir()
+	read := 0
+	opts := ParseOptions{Discard: true, OnPart: func(_ int, res *Resource) error {
+		if !res.Spilled() {
+			t.Errorf("part %s was not spilled", res.Filename)
+		}
+		data, err := res.readAll()
+		if err != nil {
+			return err
+		}
+		read += len(data)
+		return nil
+	}}
+	if err := p.ParseReader(context.Background(), strings.NewReader(archive.String()), opts); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+	if read != 5*4096 {
+		t.Errorf("OnPart read %d bytes, want %d", read, 5*4096)
+	}
+	entries, err := os.ReadDir(p.TempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 || len(p.spilled) != 0 {
+		t.Errorf("%d temp files left, %d still tracked", len(entries), len(p.spilled))
+	}
+}
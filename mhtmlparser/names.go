@@ -0,0 +1,108 @@
+package mhtmlparser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxFilenameLength keeps derived names well below common filesystem limits.
+const maxFilenameLength = 100
+
+// deriveFilename picks a stable filename for a resource. In order of preference it uses the
+// Content-Disposition filename, the last path segment of the URL, the Content-ID, and finally a
+// hash of the content, so that extracting the same archive twice yields the same names.
+func deriveFilename(res Resource, dispositionName, prefix string) string {
+	if dispositionName != "" {
+		return sanitizeFilename(dispositionName)
+	}
+	if name := urlFilename(res.URL); name != "" {
+		return withExtension(name, res.Type)
+	}
+	if res.ContentID != "" {
+		local, _, _ := strings.Cut(res.ContentID, "@")
+		return withExtension(sanitizeFilename(local), res.Type)
+	}
+	return fmt.Sprintf("%s_%s%s", prefix, contentHash(res), extensionFor(res.Type))
+}
+
+// urlFilename returns a sanitized name for a URL's last path segment. Directory URLs such as
+// "https://example.com/" map to "index"; cid: URLs use the local part of the Content-ID.
+// It returns "" when no name can be derived.
+func urlFilename(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "cid":
+		local, _, _ := strings.Cut(u.Opaque, "@")
+		if unescaped, err := url.PathUnescape(local); err == nil {
+			local = unescaped
+		}
+		return sanitizeFilename(local)
+	case "data", "about", "javascript", "blob":
+		return ""
+	}
+	if u.Path == "" && u.Host == "" {
+		return ""
+	}
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return "index"
+	}
+	return sanitizeFilename(path.Base(u.Path))
+}
+
+// withExtension appends the extension for contentType unless name already has a matching one.
+func withExtension(name, contentType string) string {
+	want := extensionFor(contentType)
+	ext := strings.ToLower(path.Ext(name))
+	if ext == want || (ext != "" && want == ".bin") {
+		return name
+	}
+	if ext != "" && normalizeContentType(mime.TypeByExtension(ext)) == contentType {
+		return name
+	}
+	return name + want
+}
+
+// contentHash returns the first 8 hex digits of the SHA-256 of the resource data.
+func contentHash(res Resource) string {
+	h := sha256.New()
+	if src, err := res.Open(); err == nil {
+		io.Copy(h, src)
+		src.Close()
+	}
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+// makeFilenamesUnique gives duplicate filenames a numeric suffix in archive order, comparing
+// case-insensitively so the names stay distinct on Windows and macOS filesystems.
+func makeFilenamesUnique(resources []Resource) {
+	taken := make(map[string]bool, len(resources))
+	for i := range resources {
+		name := resources[i].Filename
+		if taken[strings.ToLower(name)] {
+			ext := path.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			for n := 1; ; n++ {
+				candidate := fmt.Sprintf("%s_%d%s", base, n, ext)
+				if !taken[strings.ToLower(candidate)] {
+					name = candidate
+					break
+				}
+			}
+			resources[i].Filename = name
+		}
+		taken[strings.ToLower(name)] = true
+	}
+}
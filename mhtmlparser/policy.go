@@ -0,0 +1,302 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// FetchPolicy restricts the downloads made for an archive's external resources, whose URLs
+// come from an untrusted file. The zero value blocks non-public addresses, such as
+// localhost, private networks and the 169.254.169.254 metadata service, and sets no limits.
+//
+// Host lists and limits apply to every Fetcher. Addresses are checked by HTTPFetcher once
+// host names are resolved, for the first request and every redirect. When requests go
+// through a proxy, HTTPFetcher resolves each host itself and refuses hosts it cannot
+// resolve; a DNS server answering the proxy differently, as in DNS rebinding, can still
+// lead it to a non-public address.
+type FetchPolicy struct {
+	AllowPrivate  bool     // Allow loopback, private, link-local and other non-public addresses
+	AllowHosts    []string // Host globs to download from, any host when empty; "*.example.com" also matches example.com
+	DenyHosts     []string // Host globs never downloaded from, checked before AllowHosts
+	MaxDownloads  int      // Downloads per archive, no limit when 0
+	MaxTotalBytes int64    // Bytes downloaded per archive, no limit when 0
+}
+
+// nonPublicPrefixes are reserved ranges that netip.Addr has no predicate for.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use IPv4/IPv6 translation
+}
+
+// checkURL reports whether the policy allows downloading rawURL, judging by its scheme, its
+// host and, when the host is an IP address, that address. Errors wrap ErrBlocked.
+func (fp FetchPolicy) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: not an http or https URL", ErrBlocked)
+	}
+	for _, pattern := range fp.DenyHosts {
+		if matchHost(pattern, rawURL) {
+			return fmt.Errorf("%w: host %s is denied", ErrBlocked, u.Hostname())
+		}
+	}
+	if len(fp.AllowHosts) > 0 {
+		allowed := false
+		for _, pattern := range fp.AllowHosts {
+			allowed = allowed || matchHost(pattern, rawURL)
+		}
+		if !allowed {
+			return fmt.Errorf("%w: host %s is not allowed", ErrBlocked, u.Hostname())
+		}
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return fp.checkIP(ip)
+	}
+	if host := u.Hostname(); !fp.AllowPrivate && (strings.EqualFold(host, "localhost") || hasSuffixFold(host, ".localhost")) {
+		return fmt.Errorf("%w: %s is a loopback host", ErrBlocked, host)
+	}
+	return nil
+}
+
+// checkIP reports whether the policy allows connecting to ip. Errors wrap ErrBlocked.
+func (fp FetchPolicy) checkIP(ip netip.Addr) error {
+	if fp.AllowPrivate || isPublicIP(ip) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is not a public address", ErrBlocked, ip)
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address.
+func isPublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupNetIP resolves host names for checkHost; tests replace it.
+var lookupNetIP = net.DefaultResolver.LookupNetIP
+
+// checkHost resolves host and checks every address it resolves to, for requests sent
+// through a proxy, which resolves the host itself. Hosts that do not resolve are refused
+// since they cannot be checked. Errors wrap ErrBlocked.
+func (fp FetchPolicy) checkHost(ctx context.Context, host string) error {
+	if fp.AllowPrivate {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return fp.checkIP(ip)
+	}
+	ips, err := lookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: cannot resolve %s to check it: %v", ErrBlocked, host, err)
+	}
+	for _, ip := range ips {
+		if err := fp.checkIP(ip); err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// hasSuffixFold reports whether s ends with the ASCII suffix, ignoring case.
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// policyKey is the context key under which fetchExternal passes its FetchPolicy to
+// HTTPFetcher.
+type policyKey struct{}
+
+// withFetchPolicy returns ctx carrying fp, or no policy when fp is nil.
+func withFetchPolicy(ctx context.Context, fp *FetchPolicy) context.Context {
+	return context.WithValue(ctx, policyKey{}, fp)
+}
+
+// fetchPolicyFrom returns the policy carried by ctx, or nil.
+func fetchPolicyFrom(ctx context.Context) *FetchPolicy {
+	fp, _ := ctx.Value(policyKey{}).(*FetchPolicy)
+	return fp
+}
+
+// guardTransport returns a copy of rt that checks the address of every connection it opens
+// against the FetchPolicy of the request, after host names are resolved. A transport
+// without its own DialContext, such as the one of defaultTransport, checks addresses before
+// connecting; a custom DialContext is checked once connected, before anything is sent.
+// Connections to proxies are not checked, since the proxy resolves the destination; the
+// host of the request is resolved and checked instead. RoundTrippers other than
+// *http.Transport are returned unchanged.
+func guardTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = defaultTransport()
+	}
+	base, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t := base.Clone()
+
+	var proxies sync.Map // Addresses of the proxies in use, as host:port
+	if proxy := t.Proxy; proxy != nil {
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			u, err := proxy(req)
+			if u == nil || err != nil {
+				return u, err
+			}
+			if fp := fetchPolicyFrom(req.Context()); fp != nil {
+				if err := fp.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+					return nil, err
+				}
+			}
+			proxies.Store(proxyAddr(u), true)
+			return u, nil
+		}
+	}
+
+	dial := t.DialContext
+	if dial == nil {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			ControlContext: func(ctx context.Context, _, address string, _ syscall.RawConn) error {
+				return checkDialAddr(ctx, address)
+			},
+		}
+		dial = dialer.DialContext
+	} else {
+		// A custom dialer can only be checked once it is connected, before anything is sent.
+		custom := dial
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := custom(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkDialAddr(ctx, conn.RemoteAddr().String()); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+	t.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := proxies.Load(address); ok {
+			ctx = withFetchPolicy(ctx, nil)
+		}
+		return dial(ctx, network, address)
+	}
+	return t
+}
+
+// checkDialAddr checks the resolved ip:port address of a connection against the policy of ctx.
+func checkDialAddr(ctx context.Context, address string) error {
+	fp := fetchPolicyFrom(ctx)
+	if fp == nil {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unexpected address %s", ErrBlocked, address)
+	}
+	return fp.checkIP(addrPort.Addr())
+}
+
+// proxyAddr returns the host:port a transport dials to reach the proxy at u.
+func proxyAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// checkRedirect wraps an http.Client CheckRedirect function so that redirects are checked
+// against the FetchPolicy of the request. A nil next follows up to 10 redirects, like the
+// http.Client default.
+func checkRedirect(next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if fp := fetchPolicyFrom(req.Context()); fp != nil {
+			if err := fp.checkURL(req.URL.String()); err != nil {
+				return fmt.Errorf("redirect to %s: %w", req.URL, err)
+			}
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+// fetchBudget counts down the bytes an archive may still download, shared by the workers.
+type fetchBudget struct {
+	limit     int64 // 0 for no limit
+	remaining atomic.Int64
+}
+
+func newFetchBudget(limit int64) *fetchBudget {
+	b := &fetchBudget{limit: limit}
+	b.remaining.Store(limit)
+	return b
+}
+
+// exhausted reports whether the budget has been used up.
+func (b *fetchBudget) exhausted() bool {
+	return b.limit > 0 && b.remaining.Load() <= 0
+}
+
+// err returns the error for downloads past the budget.
+func (b *fetchBudget) err() error {
+	return fmt.Errorf("%w: MaxTotalBytes of %d reached", ErrFetchLimit, b.limit)
+}
+
+// reader returns r, failing once the reads through it and the budget's other readers
+// exceed the limit.
+func (b *fetchBudget) reader(r io.Reader) io.Reader {
+	if b.limit <= 0 {
+		return r
+	}
+	return &budgetReader{r: r, b: b}
+}
+
+type budgetReader struct {
+	r io.Reader
+	b *fetchBudget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.b.remaining.Add(-int64(n)) < 0 {
+		return n, r.b.err()
+	}
+	return n, err
+}
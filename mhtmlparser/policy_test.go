@@ -0,0 +1,312 @@
+package mhtmlparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchPolicyCheckURL(t *testing.T) {
+	tests := []struct {
+		policy  FetchPolicy
+		url     string
+		blocked bool
+	}{
+		{url: "http://example.com/a.png"},
+		{url: "https://93.184.215.14/a.png"},
+		{url: "ftp://example.com/a.png", blocked: true},
+		{url: "file:///etc/passwd", blocked: true},
+		{url: "http://127.0.0.1/", blocked: true},
+		{url: "http://127.1.2.3:8080/", blocked: true},
+		{url: "http://[::1]/", blocked: true},
+		{url: "http://[::ffff:127.0.0.1]/", blocked: true},
+		{url: "http://10.0.0.1/", blocked: true},
+		{url: "http://192.168.1.1/", blocked: true},
+		{url: "http://169.254.169.254/latest/meta-data/", blocked: true},
+		{url: "http://100.64.0.1/", blocked: true},
+		{url: "http://0.0.0.0/", blocked: true},
+		{url: "http://localhost:8080/", blocked: true},
+		{url: "http://LOCALHOST/", blocked: true},
+		{url: "http://app.localhost/", blocked: true},
+		{policy: FetchPolicy{AllowPrivate: true}, url: "http://127.0.0.1/"},
+		{policy: FetchPolicy{AllowPrivate: true}, url: "http://localhost/"},
+		{policy: FetchPolicy{AllowHosts: []string{"*.example.com"}}, url: "http://cdn.example.com/a.js"},
+		{policy: FetchPolicy{AllowHosts: []string{"*.example.com"}}, url: "http://example.com/a.js"},
+		{policy: FetchPolicy{AllowHosts: []string{"*.example.com"}}, url: "http://example.org/a.js", blocked: true},
+		{policy: FetchPolicy{DenyHosts: []string{"*.tracker.net"}}, url: "http://ads.tracker.net/p.gif", blocked: true},
+		{policy: FetchPolicy{AllowHosts: []string{"*.example.com"}, DenyHosts: []string{"ads.example.com"}}, url: "http://ads.example.com/a.js", blocked: true},
+		{policy: FetchPolicy{AllowPrivate: true, AllowHosts: []string{"example.com"}}, url: "http://127.0.0.1/", blocked: true},
+	}
+	for _, tt := range tests {
+		err := tt.policy.checkURL(tt.url)
+		if tt.blocked && !errors.Is(err, ErrBlocked) {
+			t.Errorf("%+v: %s not blocked, error %v", tt.policy, tt.url, err)
+		}
+		if !tt.blocked && err != nil {
+			t.Errorf("%+v: %s blocked: %v", tt.policy, tt.url, err)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f:cb07::": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"169.254.169.254":      false,
+		"198.18.0.1":           false,
+		"255.255.255.255":      false,
+		"224.0.0.1":            false,
+		"::1":                  false,
+		"fc00::1":              false,
+		"fe80::1":              false,
+		"::ffff:10.0.0.1":      false,
+	} {
+		if got := isPublicIP(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFetchExternalBlocksLoopbackByDefault(t *testing.T) {
+	srv, hits := countingServer(t, "png")
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	page := fmt.Sprintf(`<img src="%s/a.png"><script src="http://localhost:%d/b.js"></script>`, srv.URL, port)
+
+	p := New("", true)
+	parseWithFetch(t, p, page)
+	if n := hits.Load(); n != 0 {
+		t.Errorf("server received %d requests", n)
+	}
+	if n := externalCount(p); n != 0 {
+		t.Errorf("downloaded %d resources", n)
+	}
+	if w := fetchWarnings(p, ErrBlocked); len(w) != 2 {
+		t.Errorf("blocked warnings = %v, want 2", p.Warnings)
+	}
+
+	allowed := New("", true)
+	allowed.FetchPolicy.AllowPrivate = true
+	parseWithFetch(t, allowed, page)
+	if n := externalCount(allowed); n != 2 {
+		t.Errorf("AllowPrivate downloaded %d resources, want 2; warnings %v", n, allowed.Warnings)
+	}
+}
+
+// acceptLog returns a listener sending the remote address of every connection it accepts
+// on the returned channel. Connections are answered with an empty HTTP response.
+func acceptLog(t *testing.T) (net.Listener, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	accepted := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn.RemoteAddr().String()
+			go func() {
+				defer conn.Close()
+				conn.Read(make([]byte, 4096))
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			}()
+		}
+	}()
+	return ln, accepted
+}
+
+// connectionsBefore returns the number of connections ln accepted since the last call, by
+// making a probe connection that is accepted after them.
+func connectionsBefore(t *testing.T, ln net.Listener, accepted <-chan string) int {
+	t.Helper()
+	probe, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer probe.Close()
+	n := 0
+	for {
+		select {
+		case addr := <-accepted:
+			if addr == probe.LocalAddr().String() {
+				return n
+			}
+			n++
+		case <-time.After(5 * time.Second):
+			t.Fatal("probe connection not accepted")
+		}
+	}
+}
+
+func TestHTTPFetcherChecksDialedAddress(t *testing.T) {
+	ln, accepted := acceptLog(t)
+	addr := ln.Addr().String()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// A custom dialer resolves internal.test itself and is only checked once connected.
+	custom := http.DefaultTransport.(*http.Transport).Clone()
+	custom.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	tests := []struct {
+		name      string
+		opts      HTTPOptions
+		url       string
+		connected int // Connections accepted before the address is refused
+	}{
+		{"default transport", HTTPOptions{}, "http://" + addr + "/a.png", 0},
+		{"client without transport", HTTPOptions{Client: &http.Client{}}, "http://" + addr + "/a.png", 0},
+		{"custom dialer", HTTPOptions{Transport: custom}, fmt.Sprintf("http://internal.test:%d/a.png", port), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewHTTPFetcher(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := f.Fetch(withFetchPolicy(context.Background(), &FetchPolicy{}), tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, ErrBlocked) {
+				t.Errorf("error = %v, want ErrBlocked", err)
+			}
+			if n := connectionsBefore(t, ln, accepted); n != tt.connected {
+				t.Errorf("%d connections accepted, want %d", n, tt.connected)
+			}
+
+			// Without a policy, as outside a parse, the fetcher connects.
+			resp, err = f.Fetch(context.Background(), tt.url)
+			if err != nil {
+				t.Fatalf("Fetch without policy: %v", err)
+			}
+			resp.Body.Close()
+			connectionsBefore(t, ln, accepted)
+		})
+	}
+}
+
+// stubLookup makes checkHost resolve host names from hosts for the duration of the test.
+func stubLookup(t *testing.T, hosts map[string]string) {
+	t.Helper()
+	orig := lookupNetIP
+	lookupNetIP = func(_ context.Context, _, host string) ([]netip.Addr, error) {
+		addr, ok := hosts[host]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []netip.Addr{netip.MustParseAddr(addr)}, nil
+	}
+	t.Cleanup(func() { lookupNetIP = orig })
+}
+
+func TestHTTPFetcherChecksProxiedHosts(t *testing.T) {
+	internal, internalHits := countingServer(t, "secret")
+	stubLookup(t, map[string]string{
+		"public.example":           "93.184.215.14",
+		"other.example":            "93.184.215.15",
+		"metadata.google.internal": "169.254.169.254",
+		"intranet.corp":            "10.0.0.8",
+		"localhost":                "127.0.0.1",
+	})
+	// The proxy stands in for the public internet; connections to it are not checked.
+	var proxyHits atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyHits.Add(1)
+		switch r.URL.Path {
+		case "/to-ip":
+			http.Redirect(w, r, internal.URL+"/secret", http.StatusFound)
+		case "/to-localhost":
+			http.Redirect(w, r, "http://localhost/secret", http.StatusFound)
+		case "/to-internal":
+			http.Redirect(w, r, "http://intranet.corp/secret", http.StatusFound)
+		case "/to-public":
+			http.Redirect(w, r, "http://other.example/ok", http.StatusFound)
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	t.Cleanup(proxy.Close)
+
+	f, err := NewHTTPFetcher(HTTPOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withFetchPolicy(context.Background(), &FetchPolicy{})
+	for _, u := range []string{
+		"http://public.example/to-ip",
+		"http://public.example/to-localhost",
+		"http://public.example/to-internal",
+		"http://metadata.google.internal/computeMetadata/v1/",
+		"http://intranet.corp/",
+		"http://10.1.1.1/",
+		"http://unresolvable.example/",
+	} {
+		resp, err := f.Fetch(ctx, u)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !errors.Is(err, ErrBlocked) {
+			t.Errorf("Fetch(%s) error = %v, want ErrBlocked", u, err)
+		}
+	}
+	if n := internalHits.Load(); n != 0 {
+		t.Errorf("internal server received %d requests", n)
+	}
+	// Only the three redirecting requests reached the proxy.
+	if n := proxyHits.Load(); n != 3 {
+		t.Errorf("proxy received %d requests, want 3", n)
+	}
+
+	resp, err := f.Fetch(ctx, "http://public.example/to-public")
+	if err != nil {
+		t.Fatalf("redirect to a public host: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "ok" {
+		t.Errorf("body = %q, want ok", body)
+	}
+}
+
+func TestFetchPolicyLimits(t *testing.T) {
+	srv, _ := countingServer(t, strings.Repeat("x", 100))
+	page := fmt.Sprintf(`<img src="%[1]s/a.png"><img src="%[1]s/b.png"><img src="%[1]s/c.png">`, srv.URL)
+
+	p := New("", true)
+	p.FetchPolicy = FetchPolicy{AllowPrivate: true, MaxDownloads: 2}
+	parseWithFetch(t, p, page)
+	if n := externalCount(p); n != 2 {
+		t.Errorf("MaxDownloads 2: downloaded %d resources", n)
+	}
+	if w := fetchWarnings(p, ErrFetchLimit); len(w) != 1 {
+		t.Errorf("MaxDownloads 2: limit warnings = %v, want 1", p.Warnings)
+	}
+
+	p = New("", true)
+	p.FetchWorkers = 1
+	p.FetchPolicy = FetchPolicy{AllowPrivate: true, MaxTotalBytes: 150}
+	parseWithFetch(t, p, page)
+	if n := externalCount(p); n != 1 {
+		t.Errorf("MaxTotalBytes 150: downloaded %d resources, want 1", n)
+	}
+	if w := fetchWarnings(p, ErrFetchLimit); len(w) != 2 {
+		t.Errorf("MaxTotalBytes 150: limit warnings = %v, want 2", p.Warnings)
+	}
+}
@@ -0,0 +1,73 @@
+package mhtmlparser
+
+import (
+	"context"
+	"io"
+)
+
+// StageWrite is reported in Progress.Stage while ExtractResources writes files.
+const StageWrite = "write"
+
+// progressInterval is how many archive bytes are read between two byte-count progress reports.
+const progressInterval = 1 << 20
+
+// Progress is a snapshot of a running parse or extraction, passed to MHTMLParser.OnProgress.
+type Progress struct {
+	Stage          string // StageRead, StageFetch or StageWrite
+	BytesRead      int64  // Archive bytes consumed so far
+	TotalBytes     int64  // Archive size, 0 when unknown
+	Parts          int    // MIME parts processed
+	Downloads      int    // External downloads finished, whether they succeeded or not
+	TotalDownloads int    // External downloads scheduled so far
+	Written        int    // Resources written by ExtractResources
+	TotalWrites    int    // Resources selected for extraction
+}
+
+// Fraction returns how far the current stage has got, between 0 and 1, or 0 when unknown.
+func (pr Progress) Fraction() float32 {
+	var done, total int64
+	switch pr.Stage {
+	case StageRead:
+		done, total = pr.BytesRead, pr.TotalBytes
+	case StageFetch:
+		done, total = int64(pr.Downloads), int64(pr.TotalDownloads)
+	case StageWrite:
+		done, total = int64(pr.Written), int64(pr.TotalWrites)
+	}
+	if total <= 0 {
+		return 0
+	}
+	return min(float32(done)/float32(total), 1)
+}
+
+// reportProgress passes the current progress to OnProgress, if set.
+func (p *MHTMLParser) reportProgress() {
+	if p.OnProgress != nil {
+		p.OnProgress(p.progress)
+	}
+}
+
+// contextReader fails reads once its context is done, so long reads can be cancelled.
+// It counts the bytes read and reports progress every progressInterval bytes.
+type contextReader struct {
+	ctx         context.Context
+	r           io.Reader
+	p           *MHTMLParser
+	sinceReport int64
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(b)
+	if c.p != nil && n > 0 {
+		c.p.progress.BytesRead += int64(n)
+		c.sinceReport += int64(n)
+		if c.sinceReport >= progressInterval {
+			c.sinceReport = 0
+			c.p.reportProgress()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,376 @@
+package mhtmlparser
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// emptyURL replaces CSS references to pruned resources, so the browser neither shows
+// nor fetches anything.
+const emptyURL = "data:,"
+
+// PruneRule selects resources for Prune. Every field that is set must match; a rule with
+// no fields set matches nothing.
+type PruneRule struct {
+	Type    string // MIME type glob, e.g. "image/*"
+	MinSize int    // Matches resources of at least this many bytes
+	Host    string // URL host glob, e.g. "*.doubleclick.net", which also matches the bare domain
+	Source  string // "embedded", "inline", "data-uri" or "external"
+}
+
+// Match reports whether res is selected by the rule.
+func (r PruneRule) Match(res Resource) bool {
+	if r == (PruneRule{}) {
+		return false
+	}
+	if r.Type != "" {
+		if ok, _ := path.Match(strings.ToLower(r.Type), res.Type); !ok {
+			return false
+		}
+	}
+	if r.MinSize > 0 && res.Size < r.MinSize {
+		return false
+	}
+	if r.Host != "" && !matchHost(r.Host, res.URL) {
+		return false
+	}
+	return r.Source == "" || r.Source == res.Source
+}
+
+// matchHost reports whether the host of rawURL matches the glob pattern.
+func matchHost(pattern, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	pattern = strings.ToLower(pattern)
+	if ok, _ := path.Match(pattern, host); ok {
+		return true
+	}
+	return strings.HasPrefix(pattern, "*.") && host == pattern[2:]
+}
+
+// ParsePruneRules parses rules written as space-separated conditions, one rule per line or
+// separated by ";", for example "type=image/* size>=500k; host=*.doubleclick.net".
+// Conditions are type=, host=, source= and size>= (or size>), with k, m and g size suffixes.
+func ParsePruneRules(spec string) ([]PruneRule, error) {
+	var rules []PruneRule
+	for _, line := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var rule PruneRule
+		for _, field := range fields {
+			key, value, ok := strings.Cut(field, "=")
+			switch {
+			case strings.HasPrefix(field, "size>"):
+				inclusive := strings.HasPrefix(field, "size>=")
+				size, err := parseSize(strings.TrimLeft(field[len("size>"):], "="))
+				if err != nil {
+					return nil, fmt.Errorf("invalid prune rule %q: %w", line, err)
+				}
+				if !inclusive {
+					size++
+				}
+				rule.MinSize = size
+			case ok && key == "type":
+				rule.Type = value
+			case ok && key == "host":
+				rule.Host = value
+			case ok && key == "source":
+				rule.Source = value
+			default:
+				return nil, fmt.Errorf("invalid prune rule %q: unknown condition %q", line, field)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseSize parses a byte count with an optional k, m or g suffix.
+func parseSize(s string) (int, error) {
+	s = strings.TrimSuffix(strings.ToLower(s), "b")
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int(n * float64(multiplier)), nil
+}
+
+// PruneCategory holds the archive size of one kind of resource before and after Prune.
+type PruneCategory struct {
+	Name        string
+	PartsBefore int
+	PartsAfter  int
+	BytesBefore int64
+	BytesAfter  int64
+}
+
+// PruneReport describes what Prune removed.
+type PruneReport struct {
+	Categories []PruneCategory // Embedded parts by category, in a fixed order
+	Removed    []string        // URL, or filename when there is none, of every removed resource
+}
+
+// WriteTo prints the report as a table of sizes per category followed by a total.
+func (r PruneReport) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Category\tParts before\tParts after\tBytes before\tBytes after\tSaved\t")
+	var total PruneCategory
+	for _, c := range r.Categories {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t\n", c.Name, c.PartsBefore, c.PartsAfter, c.BytesBefore, c.BytesAfter, c.BytesBefore-c.BytesAfter)
+		total.PartsBefore += c.PartsBefore
+		total.PartsAfter += c.PartsAfter
+		total.BytesBefore += c.BytesBefore
+		total.BytesAfter += c.BytesAfter
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%d\t%d\t\n", total.PartsBefore, total.PartsAfter, total.BytesBefore, total.BytesAfter, total.BytesBefore-total.BytesAfter)
+	err := tw.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// pruneCategories is the order categories appear in a PruneReport.
+var pruneCategories = []string{"html", "css", "script", "image", "font", "media", "other"}
+
+// resourceCategory groups a content type into one of pruneCategories.
+func resourceCategory(contentType string) string {
+	switch {
+	case contentType == "text/html" || contentType == "application/xhtml+xml":
+		return "html"
+	case contentType == "text/css":
+		return "css"
+	case strings.Contains(contentType, "javascript") || strings.Contains(contentType, "ecmascript"):
+		return "script"
+	case strings.HasPrefix(contentType, "image/"):
+		return "image"
+	case strings.Contains(contentType, "font"):
+		return "font"
+	case strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "video/"):
+		return "media"
+	}
+	return "other"
+}
+
+// Prune removes every resource matched by any of rules, except the main document, and
+// rewrites the HTML and CSS parts that referenced them: elements that load a removed
+// resource are deleted, inline blocks are cut from their page, style attributes are
+// dropped with the stylesheet gathering them, and remaining references point at an empty
+// data: URI. Call Save to write the slimmer archive.
+func (p *MHTMLParser) Prune(rules []PruneRule) (PruneReport, error) {
+	before := p.categorySizes()
+
+	mainDoc := p.MainDocument()
+	removed := make(map[*Resource]bool)
+	var report PruneReport
+	for i := range p.Resources {
+		res := &p.Resources[i]
+		if mainDoc != nil && res.Source == "embedded" && res.Section == mainDoc.Section {
+			continue
+		}
+		if slices.ContainsFunc(rules, func(r PruneRule) bool { return r.Match(*res) }) {
+			removed[res] = true
+			name := res.URL
+			if name == "" {
+				name = res.Filename
+			}
+			report.Removed = append(report.Removed, name)
+		}
+	}
+	if len(removed) == 0 {
+		report.Categories = mergeCategories(before, before)
+		return report, nil
+	}
+
+	// Rewrite every remaining part while lookups can still find the removed resources.
+	updates := make(map[int][]byte)
+	for i, res := range p.Resources {
+		if removed[&p.Resources[i]] || res.Source != "embedded" {
+			continue
+		}
+		var data []byte
+		var err error
+		switch res.Type {
+		case "text/html":
+			data, err = p.pruneHTML(res, removed)
+		case "text/css":
+			data, err = p.pruneCSS(res, removed)
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to rewrite %s: %w", res.Filename, err)
+		}
+		if data != nil {
+			updates[i] = data
+		}
+	}
+	for i, data := range updates {
+		p.setData(i, data, p.Resources[i].Charset)
+	}
+	for i := len(p.Resources) - 1; i >= 0; i-- {
+		if removed[&p.Resources[i]] {
+			if err := p.RemoveResource(i); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	report.Categories = mergeCategories(before, p.categorySizes())
+	return report, nil
+}
+
+// pruneHTML returns an HTML part with references to removed resources taken out, or nil
+// when it referenced none of them.
+func (p *MHTMLParser) pruneHTML(res Resource, removed map[*Resource]bool) ([]byte, error) {
+	doc, base, err := parseHTMLDocument(string(res.Data), p.documentBase(res))
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	isRemoved := func(ref string) bool {
+		if target := p.LookupFrom(ref, base); target != nil && removed[target] {
+			return true
+		}
+		return matchDataURI(ref, removed) != nil
+	}
+
+	doc.Find("[src], link[href]").Each(func(_ int, s *goquery.Selection) {
+		attr := "src"
+		if goquery.NodeName(s) == "link" {
+			attr = "href"
+		}
+		if ref, _ := s.Attr(attr); isRemoved(ref) {
+			s.Remove()
+			changed = true
+		}
+	})
+	// Inline resources were extracted from the document's blocks in order, so the n-th
+	// block holding code belongs to the n-th inline resource of the document.
+	var inline []*Resource
+	for i := range p.Resources {
+		if p.Resources[i].Source == "inline" && p.Resources[i].Document == res.Section {
+			inline = append(inline, &p.Resources[i])
+		}
+	}
+	n := 0
+	doc.Find("script, style").Each(func(_ int, s *goquery.Selection) {
+		if _, exists := s.Attr("src"); exists {
+			return
+		}
+		code := strings.TrimSpace(s.Text())
+		if code == "" {
+			return
+		}
+		if n < len(inline) && removed[inline[n]] && string(inline[n].Data) == code {
+			s.Remove()
+			changed = true
+		}
+		n++
+	})
+	// The resource gathering the style attributes follows the blocks.
+	if n < len(inline) && removed[inline[n]] && strings.HasPrefix(inline[n].Filename, "inline_style_attrs_") {
+		doc.Find("[style]").RemoveAttr("style")
+		changed = true
+	}
+	rewriteReferences(doc, func(ref string) string {
+		if isRemoved(ref) {
+			changed = true
+			return emptyURL
+		}
+		return ref
+	})
+	if !changed {
+		return nil, nil
+	}
+	return renderHTML(doc)
+}
+
+// pruneCSS returns a stylesheet with references to removed resources emptied, or nil when
+// it referenced none of them.
+func (p *MHTMLParser) pruneCSS(res Resource, removed map[*Resource]bool) ([]byte, error) {
+	data, err := res.readAll()
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	css := rewriteCSSRefs(string(data), func(ref string) string {
+		if target := p.LookupFrom(ref, res.URL); (target != nil && removed[target]) || matchDataURI(ref, removed) != nil {
+			changed = true
+			return emptyURL
+		}
+		return ref
+	})
+	if !changed {
+		return nil, nil
+	}
+	return []byte(css), nil
+}
+
+// categorySizes sums the embedded parts of the archive by category.
+func (p *MHTMLParser) categorySizes() map[string]PruneCategory {
+	sizes := make(map[string]PruneCategory)
+	for _, res := range p.Resources {
+		if res.Source != "embedded" {
+			continue
+		}
+		name := resourceCategory(res.Type)
+		c := sizes[name]
+		c.PartsBefore++
+		c.BytesBefore += int64(res.Size)
+		sizes[name] = c
+	}
+	return sizes
+}
+
+// mergeCategories combines the sizes before and after pruning into report order.
+func mergeCategories(before, after map[string]PruneCategory) []PruneCategory {
+	var categories []PruneCategory
+	for _, name := range pruneCategories {
+		b, a := before[name], after[name]
+		if b.PartsBefore == 0 && a.PartsBefore == 0 {
+			continue
+		}
+		categories = append(categories, PruneCategory{
+			Name:        name,
+			PartsBefore: b.PartsBefore,
+			PartsAfter:  a.PartsBefore,
+			BytesBefore: b.BytesBefore,
+			BytesAfter:  a.BytesBefore,
+		})
+	}
+	return categories
+}
@@ -0,0 +1,92 @@
+package mhtmlparser
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// pruneArchive parses an archive of page and an image, prunes it with spec, saves it and
+// parses the saved archive again.
+func pruneArchive(t *testing.T, page, spec string) (PruneReport, *MHTMLParser) {
+	t.Helper()
+	archive := "Content-Type: multipart/related; boundary=b; type=\"text/html\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/html; charset=utf-8\r\nContent-Location: http://example.com/\r\n\r\n" + page + "\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Location: http://example.com/a.png\r\n\r\npng\r\n" +
+		"--b--\r\n"
+	p := New("", false)
+	if err := p.ParseReader(context.Background(), strings.NewReader(archive), ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+	rules, err := ParsePruneRules(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := p.Prune(rules)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	saved := New("", false)
+	if err := saved.ParseReader(context.Background(), &buf, ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader of saved archive: %v", err)
+	}
+	return report, saved
+}
+
+func TestPruneInlineScripts(t *testing.T) {
+	page := `<script src="http://example.com/a.js"></script><script> </script><script>var a = 1;</script>`
+	report, saved := pruneArchive(t, page, "source=inline")
+	if len(report.Removed) != 1 {
+		t.Fatalf("removed %v, want the inline script", report.Removed)
+	}
+	if strings.Contains(saved.HTMLContent, "var a = 1;") {
+		t.Errorf("inline script left: %s", saved.HTMLContent)
+	}
+	if !strings.Contains(saved.HTMLContent, `<script src="http://example.com/a.js"></script><script> </script>`) {
+		t.Errorf("external or empty script removed: %s", saved.HTMLContent)
+	}
+}
+
+func TestPruneInlineStyleAttributes(t *testing.T) {
+	page := `<p style="color:red">red</p><script>var a = 1;</script>`
+	report, saved := pruneArchive(t, page, "source=inline type=text/css")
+	if len(report.Removed) != 1 || !strings.HasPrefix(report.Removed[0], "inline_style_attrs_") {
+		t.Fatalf("removed %v, want the style attributes", report.Removed)
+	}
+	if strings.Contains(saved.HTMLContent, "color:red") {
+		t.Errorf("style attribute left: %s", saved.HTMLContent)
+	}
+	if !strings.Contains(saved.HTMLContent, "var a = 1;") {
+		t.Errorf("unpruned script removed: %s", saved.HTMLContent)
+	}
+}
+
+func TestPruneOnlyMatchedInlineBlock(t *testing.T) {
+	// Identical code in two blocks of different types; only the JSON-LD one is pruned.
+	page := `<script type="application/ld+json">{"a": 1}</script><script>{"a": 1}</script>`
+	report, saved := pruneArchive(t, page, "type=application/ld+json")
+	if len(report.Removed) != 1 {
+		t.Fatalf("removed %v, want one block", report.Removed)
+	}
+	if strings.Contains(saved.HTMLContent, "ld+json") || !strings.Contains(saved.HTMLContent, `<script>{"a": 1}</script>`) {
+		t.Errorf("wrong block removed: %s", saved.HTMLContent)
+	}
+}
+
+func TestPruneLeavesNoPlaceholderResources(t *testing.T) {
+	page := `<div style="background: url(a.png)"></div><p style="color:red">x</p>`
+	_, saved := pruneArchive(t, page, "type=image/*")
+	if strings.Contains(saved.HTMLContent, "a.png") {
+		t.Errorf("reference to the pruned image left: %s", saved.HTMLContent)
+	}
+	for _, res := range saved.Resources {
+		if res.Source == "data-uri" || res.Type == "image/png" {
+			t.Errorf("saved archive has %s resource %s", res.Source, res.Filename)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package mhtmlparser
+
+import (
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// savedFromRe matches the comment browsers put at the top of a saved page to record its address.
+var savedFromRe = regexp.MustCompile(`<!--\s*saved from url=\(\d+\)(\S+?)\s*-->`)
+
+// ReadSavedPage loads a page saved with a browser's "Save complete webpage" so it can be
+// written with WriteArchive. path is either the saved HTML file or the directory holding
+// it; in a directory the page is index.html, or the only HTML file with a "<name>_files"
+// folder next to it. The page comes first in the returned resources, followed by every
+// file in its "_files" folder.
+//
+// Resources are given URLs under the address in the page's "saved from url" comment, or
+// file: URLs when there is none, so the page's relative references resolve to them.
+// Text files are transcoded to UTF-8 as Parse would.
+func ReadSavedPage(path string) (Metadata, []Resource, error) {
+	page, err := findSavedPage(path)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+	info, err := os.Stat(page)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+
+	data, err := os.ReadFile(page)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+	base := fileURL(page)
+	if m := savedFromRe.FindSubmatch(data); m != nil {
+		if u, err := url.Parse(string(m[1])); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			base = u.String()
+		}
+	}
+
+	doc := savedResource(filepath.Base(page), base, data, "text/html", "page")
+	doc.Section = "1"
+	resources := []Resource{doc}
+	meta := Metadata{
+		URL:   base,
+		Title: documentTitle(string(doc.Data)),
+		Date:  info.ModTime(),
+	}
+
+	filesDir := strings.TrimSuffix(page, filepath.Ext(page)) + "_files"
+	if dirInfo, err := os.Stat(filesDir); err != nil || !dirInfo.IsDir() {
+		return meta, resources, nil
+	}
+	err = filepath.WalkDir(filesDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Dir(page), file)
+		if err != nil {
+			return err
+		}
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(file))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		res := savedResource(d.Name(), resolveURL(base, strings.Join(segments, "/")), data, contentType, "resource")
+		res.Section = strconv.Itoa(len(resources) + 1)
+		resources = append(resources, res)
+		return nil
+	})
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("failed to read %s: %w", filesDir, err)
+	}
+	makeFilenamesUnique(resources)
+	return meta, resources, nil
+}
+
+// savedResource builds an embedded resource from a file of a saved page.
+func savedResource(name, location string, data []byte, rawContentType, prefix string) Resource {
+	contentType := normalizeContentType(rawContentType)
+	data, charsetName, _ := decodeCharset(data, rawContentType, contentType)
+	res := Resource{
+		Type:    contentType,
+		Data:    data,
+		Size:    len(data),
+		Source:  "embedded",
+		Charset: charsetName,
+		URL:     location,
+	}
+	res.Filename = deriveFilename(res, name, prefix)
+	return res
+}
+
+// findSavedPage returns the HTML file of a saved page given the file itself or its directory.
+func findSavedPage(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	index := filepath.Join(path, "index.html")
+	if fileExists(index) {
+		return index, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var pages, withFiles []string
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".html" && ext != ".htm") {
+			continue
+		}
+		page := filepath.Join(path, entry.Name())
+		pages = append(pages, page)
+		if fileExists(strings.TrimSuffix(page, filepath.Ext(page)) + "_files") {
+			withFiles = append(withFiles, page)
+		}
+	}
+	switch {
+	case len(withFiles) == 1:
+		return withFiles[0], nil
+	case len(withFiles) == 0 && len(pages) == 1:
+		return pages[0], nil
+	}
+	return "", fmt.Errorf("no single saved page found in %s", path)
+}
+
+// fileURL returns the file: URL of a local path.
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,214 @@
+package mhtmlparser
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxFrameDepth limits how deeply frames and @import rules are inlined into a single file.
+const maxFrameDepth = 8
+
+// scriptCloseRe matches a closing script tag, which would end an inlined script early.
+var scriptCloseRe = regexp.MustCompile(`(?i)</(script)`)
+
+// WriteSingleFile writes the main document to w as one self-contained HTML file. Stylesheets
+// and scripts from the archive are inlined as <style> and <script> blocks, frames as srcdoc
+// documents, and every other archived resource as a data: URI. References to resources that
+// are not in the archive are made absolute.
+func (p *MHTMLParser) WriteSingleFile(w io.Writer) error {
+	return p.WriteSingleFileContext(context.Background(), w)
+}
+
+// WriteSingleFileContext is like WriteSingleFile but stops when ctx is cancelled.
+func (p *MHTMLParser) WriteSingleFileContext(ctx context.Context, w io.Writer) error {
+	mainDoc := p.MainDocument()
+	if mainDoc == nil {
+		return errors.New("no HTML document to export")
+	}
+	base := mainDoc.URL
+	if base == "" {
+		base = p.BaseURL
+	}
+
+	s := &singleFile{ctx: ctx, p: p, inlining: make(map[*Resource]bool)}
+	data, err := s.html(mainDoc.Content, base, 0)
+	if err == nil {
+		err = s.err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// singleFile inlines the resources of one document tree. The first failure is kept in err
+// so the rewrite callbacks, which cannot return errors, can still report it.
+type singleFile struct {
+	ctx      context.Context
+	p        *MHTMLParser
+	inlining map[*Resource]bool // Stylesheets and frames being inlined, to break cycles
+	err      error
+}
+
+// html inlines everything an HTML document references and returns the rendered result.
+func (s *singleFile) html(content, base string, depth int) ([]byte, error) {
+	doc, base, err := parseHTMLDocument(content, base)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hints for fetching resources that are now part of the file only add weight.
+	doc.Find("link[rel~=preload], link[rel~=prefetch], link[rel~=modulepreload]").Remove()
+
+	doc.Find("link[rel~=stylesheet][href]").Each(func(_ int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		res := s.lookup(href, base)
+		if res == nil || res.Type != "text/css" || s.inlining[res] {
+			return
+		}
+		css, ok := s.css(res, depth)
+		if !ok {
+			return
+		}
+		style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+		if media, ok := sel.Attr("media"); ok {
+			style.Attr = append(style.Attr, html.Attribute{Key: "media", Val: media})
+		}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+		sel.ReplaceWithNodes(style)
+	})
+
+	doc.Find("script[src]").Each(func(_ int, sel *goquery.Selection) {
+		src, _ := sel.Attr("src")
+		res := s.lookup(src, base)
+		if res == nil {
+			return
+		}
+		data, ok := s.read(res)
+		if !ok {
+			return
+		}
+		sel.RemoveAttr("src")
+		setRawText(sel, scriptCloseRe.ReplaceAllString(string(data), `<\/$1`))
+	})
+
+	if depth < maxFrameDepth {
+		doc.Find("iframe[src], frame[src]").Each(func(_ int, sel *goquery.Selection) {
+			src, _ := sel.Attr("src")
+			res := s.lookup(src, base)
+			if res == nil || res.Type != "text/html" || s.inlining[res] {
+				return
+			}
+			s.inlining[res] = true
+			frame, err := s.html(string(res.Data), s.p.documentBase(*res), depth+1)
+			delete(s.inlining, res)
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			if goquery.NodeName(sel) == "iframe" {
+				sel.RemoveAttr("src")
+				sel.SetAttr("srcdoc", string(frame))
+			} else {
+				sel.SetAttr("src", dataURI("text/html", frame))
+			}
+		})
+	}
+
+	rewriteReferences(doc, func(ref string) string {
+		res := s.lookup(ref, base)
+		// Other archived pages are navigated to, not embedded, and browsers refuse
+		// to navigate to data: URIs.
+		if res == nil || res.Type == "text/html" {
+			return absoluteLink(base, ref)
+		}
+		return s.uri(res, depth)
+	})
+	dropIntegrity(doc)
+	return renderHTML(doc)
+}
+
+// css returns a stylesheet with its url() references and @import rules inlined.
+func (s *singleFile) css(res *Resource, depth int) (string, bool) {
+	data, ok := s.read(res)
+	if !ok {
+		return "", false
+	}
+	s.inlining[res] = true
+	defer delete(s.inlining, res)
+	return rewriteCSSRefs(string(data), func(ref string) string {
+		target := s.lookup(ref, res.URL)
+		if target == nil {
+			return absoluteLink(res.URL, ref)
+		}
+		return s.uri(target, depth+1)
+	}), true
+}
+
+// uri returns a data: URI holding res. Imported stylesheets are inlined recursively.
+func (s *singleFile) uri(res *Resource, depth int) string {
+	if res.Type == "text/css" {
+		if s.inlining[res] || depth >= maxFrameDepth {
+			return res.URL
+		}
+		css, ok := s.css(res, depth)
+		if !ok {
+			return res.URL
+		}
+		return dataURI(res.Type, []byte(css))
+	}
+	data, ok := s.read(res)
+	if !ok {
+		return res.URL
+	}
+	return dataURI(res.Type, data)
+}
+
+// lookup resolves ref against base, skipping references that are already inline.
+func (s *singleFile) lookup(ref, base string) *Resource {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(strings.ToLower(ref), "data:") {
+		return nil
+	}
+	return s.p.LookupFrom(ref, base)
+}
+
+// read returns the data of res, recording the first failure.
+func (s *singleFile) read(res *Resource) ([]byte, bool) {
+	if err := s.ctx.Err(); err != nil {
+		s.fail(err)
+		return nil, false
+	}
+	data, err := res.readAll()
+	if err != nil {
+		s.fail(err)
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *singleFile) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// dataURI encodes data as a base64 data: URI. Text is always UTF-8 after parsing.
+func dataURI(contentType string, data []byte) string {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if isTextType(contentType) {
+		contentType += ";charset=utf-8"
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
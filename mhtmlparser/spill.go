@@ -0,0 +1,101 @@
+package mhtmlparser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/text/transform"
+)
+
+// Open returns a reader over the resource data, whether it is held in Data or was spilled to disk.
+func (r Resource) Open() (io.ReadCloser, error) {
+	if r.spillPath != "" {
+		return os.Open(r.spillPath)
+	}
+	return io.NopCloser(bytes.NewReader(r.Data)), nil
+}
+
+// Spilled reports whether the resource data lives in a temporary file rather than in Data.
+func (r Resource) Spilled() bool {
+	return r.spillPath != ""
+}
+
+// readAll returns the full resource data, reading it back from disk if it was spilled.
+func (r Resource) readAll() ([]byte, error) {
+	if r.spillPath == "" {
+		return r.Data, nil
+	}
+	return os.ReadFile(r.spillPath)
+}
+
+// Close removes the temporary files holding spilled parts. Resources that were
+// spilled can no longer be opened afterwards.
+func (p *MHTMLParser) Close() error {
+	var errs []error
+	for _, path := range p.spilled {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	p.spilled = nil
+	return errors.Join(errs...)
+}
+
+// removeSpill deletes the temporary file of a spilled resource, such as a part dropped by
+// ParseOptions.Discard, so streaming an archive does not keep it on disk.
+func (p *MHTMLParser) removeSpill(res *Resource) error {
+	if res.spillPath == "" {
+		return nil
+	}
+	p.spilled = slices.DeleteFunc(p.spilled, func(path string) bool { return path == res.spillPath })
+	path := res.spillPath
+	res.spillPath = ""
+	return os.Remove(path)
+}
+
+// readBody reads a decoded part body into res. Bodies larger than SpillThreshold are streamed
+// to a temporary file instead of being kept in memory; HTML documents always stay in memory.
+// The returned error is either a *CorruptBodyError with the decoded data kept, or a read failure.
+func (p *MHTMLParser) readBody(body io.Reader, rawContentType string, index int, res *Resource) error {
+	if p.SpillThreshold <= 0 || res.Type == "text/html" {
+		data, err := io.ReadAll(body)
+		res.Data = data
+		return err
+	}
+
+	// Read at least 1 KB so that the charset of a spilled text part can still be detected.
+	head, err := io.ReadAll(io.LimitReader(body, max(p.SpillThreshold+1, 1024)))
+	if err != nil || int64(len(head)) <= p.SpillThreshold {
+		res.Data = head
+		return err
+	}
+
+	var src io.Reader = io.MultiReader(bytes.NewReader(head), body)
+	dec, name, err := textDecoder(head, rawContentType, res.Type)
+	if err != nil {
+		p.warn(Warning{Part: index, Section: res.Section, Stage: StageCharset, URL: res.URL, Err: err})
+	}
+	res.Charset = name
+	if dec != nil {
+		head = fixCharsetDeclaration(head, res.Type)
+		src = transform.NewReader(io.MultiReader(bytes.NewReader(head), body), dec)
+	}
+
+	file, err := os.CreateTemp(p.TempDir, "mhtml-part-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	p.spilled = append(p.spilled, file.Name())
+	res.spillPath = file.Name()
+
+	n, copyErr := io.Copy(file, src)
+	res.Size = int(n)
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	return copyErr
+}
@@ -0,0 +1,96 @@
+package mhtmlparser
+
+import (
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// PartNode is a node in the MIME structure of a parsed archive. Multipart containers such as
+// multipart/alternative have Children; leaf parts correspond to the Resource with the same Section.
+type PartNode struct {
+	Section  string // IMAP-style part number such as "2.1", empty for the archive root
+	Type     string // Media type without parameters
+	Header   textproto.MIMEHeader
+	Parent   *PartNode
+	Children []*PartNode
+}
+
+// IsMultipart reports whether the node is a multipart container.
+func (n *PartNode) IsMultipart() bool {
+	return strings.HasPrefix(n.Type, "multipart/")
+}
+
+// Walk calls fn for n and every node below it in document order, stopping if fn returns false.
+func (n *PartNode) Walk(fn func(*PartNode) bool) bool {
+	if !fn(n) {
+		return false
+	}
+	for _, child := range n.Children {
+		if !child.Walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Node returns the node with the given section number, or nil if there is none.
+func (p *MHTMLParser) Node(section string) *PartNode {
+	var found *PartNode
+	if p.Root != nil {
+		p.Root.Walk(func(n *PartNode) bool {
+			if n.Section == section {
+				found = n
+			}
+			return found == nil
+		})
+	}
+	return found
+}
+
+// ContainerOf returns the multipart container res was read from, such as the multipart/alternative
+// it is one alternative of. It returns nil for inline and external resources.
+func (p *MHTMLParser) ContainerOf(res *Resource) *PartNode {
+	if res.Section == "" {
+		return nil
+	}
+	if n := p.Node(res.Section); n != nil {
+		return n.Parent
+	}
+	return nil
+}
+
+// attachNode adds a node for res, a part added by AddResource, to the end of Root.
+func (p *MHTMLParser) attachNode(res Resource) {
+	if p.Root == nil {
+		p.Root = &PartNode{Type: "multipart/related"}
+	}
+	p.Root.Children = append(p.Root.Children, &PartNode{Section: res.Section, Type: res.Type, Header: res.Header, Parent: p.Root})
+}
+
+// detachNode takes the node with the given section out of Root, then any container it leaves empty.
+func (p *MHTMLParser) detachNode(section string) {
+	n := p.Node(section)
+	if section == "" || n == nil {
+		return
+	}
+	for parent := n.Parent; parent != nil; n, parent = parent, parent.Parent {
+		parent.Children = slices.DeleteFunc(parent.Children, func(c *PartNode) bool { return c == n })
+		if len(parent.Children) > 0 || parent == p.Root {
+			break
+		}
+	}
+}
+
+// ResourceFor returns the resource read from a leaf node, or nil for containers and discarded parts.
+func (p *MHTMLParser) ResourceFor(n *PartNode) *Resource {
+	if n == nil || n.IsMultipart() {
+		return nil
+	}
+	for i := range p.Resources {
+		if p.Resources[i].Section == n.Section {
+			return &p.Resources[i]
+		}
+	}
+	return nil
+}
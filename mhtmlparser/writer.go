@@ -0,0 +1,295 @@
+package mhtmlparser
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// defaultGenerator is written as the From header when Metadata.Generator is empty.
+const defaultGenerator = "Saved by mhtmlExtractor"
+
+// base64LineLength is the maximum length of a base64 body line, as required by RFC 2045.
+const base64LineLength = 76
+
+// Writer writes an MHTML archive: a multipart/related MIME message whose first part is the
+// saved page, as described in RFC 2557. Call WriteHeader once, WriteResource for the main
+// document and then each of its resources, and Close to finish the archive.
+type Writer struct {
+	w           *bufio.Writer
+	mw          *multipart.Writer
+	wroteHeader bool
+}
+
+// NewWriter returns a Writer that writes an archive to w with a random boundary.
+func NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriter(w)
+	return &Writer{w: bw, mw: multipart.NewWriter(bw)}
+}
+
+// Boundary returns the boundary that separates the parts of the archive.
+func (w *Writer) Boundary() string {
+	return w.mw.Boundary()
+}
+
+// SetBoundary overrides the random boundary. It must be called before WriteHeader.
+func (w *Writer) SetBoundary(boundary string) error {
+	if w.wroteHeader {
+		return errors.New("mhtml: SetBoundary called after WriteHeader")
+	}
+	return w.mw.SetBoundary(boundary)
+}
+
+// WriteHeader writes the top-level header of the archive from meta. Headers in meta.Headers
+// are kept as they were read unless the Metadata field derived from them has changed,
+// except for the ones that describe the multipart structure.
+func (w *Writer) WriteHeader(meta Metadata) error {
+	if w.wroteHeader {
+		return errors.New("mhtml: WriteHeader called twice")
+	}
+	w.wroteHeader = true
+
+	generator := meta.Generator
+	if generator == "" {
+		generator = defaultGenerator
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("From", "<"+generator+">")
+	if meta.URL != "" {
+		header.Set("Snapshot-Content-Location", meta.URL)
+	}
+	if meta.Title != "" {
+		header.Set("Subject", mime.QEncoding.Encode("utf-8", meta.Title))
+	}
+	if !meta.Date.IsZero() {
+		header.Set("Date", meta.Date.Format(time.RFC1123Z))
+	}
+	header.Set("MIME-Version", "1.0")
+
+	// Keep the original spelling of headers whose meaning is unchanged.
+	orig := parseMetadata(meta.Headers)
+	for key, same := range map[string]bool{
+		"From":                      meta.Generator != "" && orig.Generator == meta.Generator,
+		"Snapshot-Content-Location": orig.URL == meta.URL,
+		"Subject":                   orig.Title == meta.Title,
+		"Date":                      orig.Date.Equal(meta.Date),
+		"MIME-Version":              true,
+	} {
+		if values := meta.Headers.Values(key); same && len(values) > 0 {
+			header[textproto.CanonicalMIMEHeaderKey(key)] = values
+		}
+	}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/related", map[string]string{
+		"type":     "text/html",
+		"boundary": w.mw.Boundary(),
+	}))
+
+	order := []string{"From", "Snapshot-Content-Location", "Subject", "Date", "MIME-Version", "Content-Type"}
+	var extra []string
+	for key := range meta.Headers {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		derived := slices.ContainsFunc(order, func(k string) bool { return textproto.CanonicalMIMEHeaderKey(k) == key })
+		if !derived && key != "Content-Transfer-Encoding" {
+			extra = append(extra, key)
+		}
+	}
+	slices.Sort(extra)
+	for _, key := range extra {
+		header[key] = meta.Headers.Values(key)
+	}
+
+	for _, key := range append(order, extra...) {
+		for _, value := range header.Values(key) {
+			if err := writeHeaderField(w.w, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := w.w.WriteString("\r\n")
+	return err
+}
+
+// WriteResource writes res as the next part of the archive. Text is written quoted-printable
+// and everything else base64. Text data is taken to be UTF-8, as the parser leaves it, and
+// labelled so unless it is still in a charset the parser could not transcode.
+// Headers in res.Header that do not describe the body, such as Content-Disposition, are kept.
+func (w *Writer) WriteResource(res Resource) error {
+	if !w.wroteHeader {
+		if err := w.WriteHeader(Metadata{}); err != nil {
+			return err
+		}
+	}
+
+	header := textproto.MIMEHeader{}
+	for key, values := range res.Header {
+		switch textproto.CanonicalMIMEHeaderKey(key) {
+		case "Content-Type", "Content-Transfer-Encoding", "Content-Location", "Content-Id", "Content-Length":
+			continue
+		}
+		header[textproto.CanonicalMIMEHeaderKey(key)] = values
+	}
+
+	contentType := res.Type
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	switch {
+	case isTextType(contentType) && utf8Data(res):
+		header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": "utf-8"}))
+	case res.Header.Get("Content-Type") != "":
+		header.Set("Content-Type", res.Header.Get("Content-Type"))
+	default:
+		header.Set("Content-Type", contentType)
+	}
+	if res.URL != "" {
+		header.Set("Content-Location", res.URL)
+	}
+	if res.ContentID != "" {
+		header.Set("Content-ID", "<"+trimContentID(res.ContentID)+">")
+	}
+	// Spilled parts may be too large to scan for line breaks and are streamed as base64.
+	text := isTextType(contentType) && !res.Spilled()
+	if text {
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+	} else {
+		header.Set("Content-Transfer-Encoding", "base64")
+	}
+	for key, values := range header {
+		clean := make([]string, len(values))
+		for i, value := range values {
+			clean[i] = headerValue(value)
+		}
+		header[key] = clean
+	}
+
+	part, err := w.mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if text {
+		qp := quotedprintable.NewWriter(part)
+		// Line breaks other than CRLF would come back as CRLF, so they are encoded too.
+		qp.Binary = !crlfOnly(res.Data)
+		if _, err := qp.Write(res.Data); err != nil {
+			return err
+		}
+		return qp.Close()
+	}
+
+	r, err := res.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	enc := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: part, max: base64LineLength})
+	if _, err := io.Copy(enc, r); err != nil {
+		return fmt.Errorf("failed to write %s: %w", res.Filename, err)
+	}
+	return enc.Close()
+}
+
+// Close writes the closing boundary and flushes the archive. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if !w.wroteHeader {
+		if err := w.WriteHeader(Metadata{}); err != nil {
+			return err
+		}
+	}
+	if err := w.mw.Close(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// WriteArchive writes an MHTML archive to w holding resources, the first of which should
+// be the main HTML document.
+func WriteArchive(w io.Writer, meta Metadata, resources []Resource) error {
+	mw := NewWriter(w)
+	if err := mw.WriteHeader(meta); err != nil {
+		return err
+	}
+	for _, res := range resources {
+		if err := mw.WriteResource(res); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// utf8Data reports whether the data of a text resource is UTF-8, which is the case unless
+// the parser met a charset it could not transcode.
+func utf8Data(res Resource) bool {
+	if res.Charset == "" {
+		return true
+	}
+	enc, _ := charset.Lookup(res.Charset)
+	return enc != nil
+}
+
+// crlfOnly reports whether every line break in data is a CRLF pair.
+func crlfOnly(data []byte) bool {
+	for i, c := range data {
+		switch c {
+		case '\r':
+			if i+1 >= len(data) || data[i+1] != '\n' {
+				return false
+			}
+		case '\n':
+			if i == 0 || data[i-1] != '\r' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeHeaderField writes one unfolded header line.
+func writeHeaderField(w io.Writer, key, value string) error {
+	_, err := fmt.Fprintf(w, "%s: %s\r\n", key, headerValue(value))
+	return err
+}
+
+// headerValue replaces line breaks in a header value so it cannot start a new header.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// lineWriter inserts a CRLF after every max bytes written.
+type lineWriter struct {
+	w   io.Writer
+	max int
+	n   int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if l.n == l.max {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.n = 0
+		}
+		chunk := min(len(p), l.max-l.n)
+		n, err := l.w.Write(p[:chunk])
+		written += n
+		l.n += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
@@ -0,0 +1,139 @@
+package mhtmlparser
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// roundTrip writes resources as an archive and parses it back with p.
+func roundTrip(t *testing.T, p *MHTMLParser, meta Metadata, resources []Resource) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, meta, resources); err != nil {
+		t.Fatalf("WriteArchive: %v", err)
+	}
+	if err := p.ParseReader(context.Background(), bytes.NewReader(buf.Bytes()), ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	if len(p.Warnings) > 0 {
+		t.Fatalf("warnings: %v", p.Warnings)
+	}
+	return buf.Bytes()
+}
+
+// embedded returns the embedded resources of p, reading spilled data back from disk.
+func embedded(t *testing.T, p *MHTMLParser) []Resource {
+	t.Helper()
+	var out []Resource
+	for _, res := range p.Resources {
+		if res.Source != "embedded" {
+			continue
+		}
+		data, err := res.readAll()
+		if err != nil {
+			t.Fatalf("reading %s: %v", res.Filename, err)
+		}
+		res.Data = data
+		out = append(out, res)
+	}
+	return out
+}
+
+func TestWriteArchiveRoundTrip(t *testing.T) {
+	binary := make([]byte, 3000)
+	rand.New(rand.NewSource(1)).Read(binary)
+	resources := []Resource{
+		{Type: "text/html", URL: "http://example.com/", Data: []byte("<html><body><img src=logo.png></body></html>")},
+		{Type: "text/plain", URL: "http://example.com/lf.txt", Data: []byte("unix\nline\nbreaks\n")},
+		{Type: "text/plain", URL: "http://example.com/cr.txt", Data: []byte("old\rmac\rbreaks\r")},
+		{Type: "text/plain", URL: "http://example.com/mixed.txt", Data: []byte("trailing space \r\ntrailing tab\t\r\nmixed\n\rend  ")},
+		{Type: "text/css", URL: "http://example.com/long.css", Data: []byte(strings.Repeat("body{color:red}", 20) + "\r\n=3D é\r\n")},
+		{Type: "image/png", URL: "http://example.com/logo.png", ContentID: "logo@example.com", Data: binary},
+	}
+	meta := Metadata{URL: "http://example.com/", Title: "Round trip"}
+
+	p := New("", false)
+	roundTrip(t, p, meta, resources)
+	got := embedded(t, p)
+	if len(got) != len(resources) {
+		t.Fatalf("parsed %d parts, want %d", len(got), len(resources))
+	}
+	for i, want := range resources {
+		if got[i].URL != want.URL || got[i].Type != want.Type || got[i].ContentID != want.ContentID {
+			t.Errorf("part %d is %s %s <%s>, want %s %s <%s>", i, got[i].Type, got[i].URL, got[i].ContentID, want.Type, want.URL, want.ContentID)
+		}
+		if !bytes.Equal(got[i].Data, want.Data) {
+			t.Errorf("part %d (%s): data changed\n got %q\nwant %q", i, want.URL, got[i].Data, want.Data)
+		}
+	}
+	if p.Metadata.URL != meta.URL || p.Metadata.Title != meta.Title {
+		t.Errorf("metadata = %q %q, want %q %q", p.Metadata.URL, p.Metadata.Title, meta.URL, meta.Title)
+	}
+}
+
+func TestWriteArchiveRoundTripSpilled(t *testing.T) {
+	binary := make([]byte, 8000)
+	rand.New(rand.NewSource(2)).Read(binary)
+	text := []byte(strings.Repeat("spilled line\n", 500))
+	resources := []Resource{
+		{Type: "text/html", URL: "http://example.com/", Data: []byte("<p>page</p>")},
+		{Type: "application/octet-stream", URL: "http://example.com/blob.bin", Data: binary},
+		{Type: "text/plain", URL: "http://example.com/big.txt", Data: text},
+	}
+
+	// The first parse spills the large parts, which are then written back from disk.
+	first := New("", false)
+	first.SpillThreshold = 1024
+	first.TempDir = t.TempDir()
+	roundTrip(t, first, Metadata{}, resources)
+	spilled := embedded(t, first)
+	for _, res := range first.Resources[1:3] {
+		if !res.Spilled() {
+			t.Fatalf("%s was not spilled", res.URL)
+		}
+	}
+
+	second := New("", false)
+	second.SpillThreshold = 1024
+	second.TempDir = t.TempDir()
+	roundTrip(t, second, Metadata{}, first.Resources)
+	got := embedded(t, second)
+	for i, want := range resources {
+		if !bytes.Equal(spilled[i].Data, want.Data) {
+			t.Errorf("part %d (%s): data changed by the first parse", i, want.URL)
+		}
+		if !bytes.Equal(got[i].Data, want.Data) {
+			t.Errorf("part %d (%s): data changed by writing a spilled part", i, want.URL)
+		}
+	}
+}
+
+func TestWriteArchiveRoundTripTranscoded(t *testing.T) {
+	archive := "Content-Type: multipart/related; boundary=b; type=\"text/html\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/html; charset=windows-1252\r\nContent-Location: http://example.com/\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\n" +
+		"<meta charset=3D\"windows-1252\"><p>caf=E9 =80 =96</p>\r\n" +
+		"--b--\r\n"
+	p := New("", false)
+	if err := p.ParseReader(context.Background(), strings.NewReader(archive), ParseOptions{}); err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+	page := p.Resources[0]
+	want := "<meta charset=\"utf-8\"><p>café € –</p>"
+	if string(page.Data) != want || page.Charset != "windows-1252" {
+		t.Fatalf("parsed %q in %s, want %q in windows-1252", page.Data, page.Charset, want)
+	}
+
+	again := New("", false)
+	written := roundTrip(t, again, p.Metadata, p.Resources[:1])
+	if !bytes.Contains(written, []byte("charset=utf-8")) {
+		t.Errorf("transcoded part not labelled UTF-8:\n%s", written)
+	}
+	if got := again.Resources[0]; !bytes.Equal(got.Data, page.Data) || got.Charset != "utf-8" {
+		t.Errorf("round trip gave %q in %s, want %q in utf-8", got.Data, got.Charset, page.Data)
+	}
+}